@@ -1,13 +1,27 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"crypto/tls"
+	"expvar"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/georgiev098/golang-basic-crud-api/internal/api/middleware"
+	"github.com/georgiev098/golang-basic-crud-api/internal/handlers"
+	"github.com/georgiev098/golang-basic-crud-api/internal/mailer"
 	"github.com/georgiev098/golang-basic-crud-api/internal/middlewares"
+	"github.com/georgiev098/golang-basic-crud-api/internal/mtls"
+	"github.com/georgiev098/golang-basic-crud-api/internal/oidc"
+	"github.com/georgiev098/golang-basic-crud-api/internal/proxyproto"
+	"github.com/georgiev098/golang-basic-crud-api/internal/ratelimit"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/cache"
 	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
 	"github.com/georgiev098/golang-basic-crud-api/internal/router"
 	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
@@ -17,11 +31,36 @@ const PORT = "3000"
 
 func main() {
 	// connect to DB
-	db, err := sqlconnect.ConnectToDB("school")
+	_, err := sqlconnect.ConnectToDB("")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	defer sqlconnect.CloseAll()
+
+	err = sqlconnect.BootstrapExecAccountDB(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handlers.Mailer, err = mailer.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cacheOptions, err := cache.OptionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cacheOptions.Size > 0 {
+		teachers := cache.NewTeachers(handlers.Teachers, cacheOptions)
+		handlers.Teachers = teachers
+		// hit and miss counters, served on GET /debug/vars
+		expvar.Publish("teacher_cache", expvar.Func(func() any { return teachers.Stats() }))
+	}
+
+	if cfg, ok := oidc.ConfigFromEnv(); ok {
+		handlers.OIDC = oidc.NewProvider(cfg)
+	}
 
 	cert := "certs/localhost.crt"
 	key := "certs/localhost.key"
@@ -34,16 +73,105 @@ func main() {
 		MinVersion: tls.VersionTLS12,
 	}
 
-	// rl := middlewares.NewRateLimiter(5, time.Minute)
+	err = mtls.ConfigureFromEnv(tlsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if path := os.Getenv("TLS_CLIENT_IDENTITIES"); path != "" {
+		middlewares.ClientCertIdentities, err = mtls.LoadIdentities(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// load balancers and reverse proxies allowed to name the client
+	trustedProxies, err := utils.ParseCIDRs(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal("TRUSTED_PROXIES: ", err)
+	}
+
+	// the header those proxies name the client in, the others are ignored
+	// since clients can send them through the proxy themselves
+	clientIPHeader, err := middlewares.ParseClientIPHeader(cmp.Or(os.Getenv("TRUSTED_PROXY_HEADER"), "X-Forwarded-For"))
+	if err != nil {
+		log.Fatal("TRUSTED_PROXY_HEADER: ", err)
+	}
+
+	// IP_RULES_FILE restricts routes to networks, see middlewares.IPRule
+	ipFilter := func(next http.Handler) http.Handler { return next }
+	if path := os.Getenv("IP_RULES_FILE"); path != "" {
+		filter, err := middlewares.NewIPFilter(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		filter.Watch(utils.DurationFromEnv("IP_RULES_RELOAD_INTERVAL", 30*time.Second))
+		ipFilter = filter.Middleware
+	}
+
+	rateLimitStore, err := ratelimit.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// every request per client IP, before credentials are checked. One
+	// address may be the NAT of a whole school, so these only stop floods;
+	// the per-principal limit below and the login throttle per account do
+	// the rest
+	ipLoginLimit := middlewares.RateLimitPolicy{Limit: utils.IntFromEnv("IP_RATE_LIMIT_LOGIN", 300), Window: time.Minute}
+	ipLimiter := middlewares.NewIPRateLimiter(rateLimitStore, middlewares.RateLimitPolicy{Limit: utils.IntFromEnv("IP_RATE_LIMIT", 6000), Window: time.Minute},
+		middlewares.RateLimitRule{Pattern: "POST /auth/login", Policy: ipLoginLimit},
+		middlewares.RateLimitRule{Pattern: "POST /auth/login/2fa", Policy: ipLoginLimit},
+		middlewares.RateLimitRule{Pattern: "POST /auth/password/forgot", Policy: middlewares.RateLimitPolicy{Limit: utils.IntFromEnv("IP_RATE_LIMIT_PASSWORD_RESET", 100), Window: time.Minute}},
+		middlewares.RateLimitRule{Pattern: "/auth/", Policy: middlewares.RateLimitPolicy{Limit: utils.IntFromEnv("IP_RATE_LIMIT_AUTH", 1000), Window: time.Minute}},
+	)
+	// then per API key or user once they are known
+	rl := middlewares.NewRateLimiter(rateLimitStore, middlewares.RateLimitPolicy{Limit: 100, Window: time.Minute})
+
+	// CORS_ALLOWED_ORIGINS lists the web apps, e.g.
+	// "https://app.example.org, https://*.schools.example.org"
+	allowedOrigins := strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",")
+	for i := range allowedOrigins {
+		allowedOrigins[i] = strings.TrimSpace(allowedOrigins[i])
+	}
+	if os.Getenv("CORS_ALLOWED_ORIGINS") == "" {
+		allowedOrigins = []string{"https://localhost:3000"}
+	}
+
+	corsOptions := middlewares.CORSOptions{
+		Default: middlewares.CORSPolicy{
+			AllowedOrigins:   allowedOrigins,
+			AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders:   []string{"Content-Type", "Content-Encoding", "Authorization", "X-CSRF-Token", "X-Tenant-ID", "X-Request-ID", "If-None-Match", "If-Modified-Since"},
+			ExposedHeaders:   []string{"ETag", "X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			AllowCredentials: true,
+			MaxAge:           time.Hour,
+		},
+	}
+
+	if err := corsOptions.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	hppOptions := middlewares.HPPOptions{
-		CheckQuery:                  true,
-		CheckBody:                   true,
-		CheckBodyOnlyForContentType: "application/x-www-from-urlencoded",
-		Whitelist:                   []string{"sortBy", "sortOrder", "name", "age", "class"},
+		CheckQuery: true,
+		CheckForm:  true,
+		CheckJSON:  true,
+		Default:    middlewares.ParamFirst,
+		Params: map[string]middlewares.ParamStrategy{
+			"sort-by": middlewares.ParamAllowArray,
+			// single sign-on callbacks must not be ambiguous
+			"code":  middlewares.ParamReject,
+			"state": middlewares.ParamReject,
+		},
+	}
+
+	// bulk imports may send gzipped bodies, capped once decompressed
+	decompressionOptions := middleware.DecompressionOptions{
+		MaxSize: int64(utils.IntFromEnv("REQUEST_BODY_MAX_BYTES", 10<<20)),
 	}
 
-	secureMux := utils.ApplyMiddlewares(router, middlewares.Hpp(hppOptions), middleware.Compression)
+	secureMux := utils.ApplyMiddlewares(router, rl.Middleware, middlewares.Authenticate, ipFilter, middlewares.Tenant, middlewares.Hpp(hppOptions), middleware.DecompressionWith(decompressionOptions), middleware.Compression, ipLimiter.Middleware, middlewares.Cors(corsOptions), middlewares.RequestID, middlewares.ClientIP(trustedProxies, clientIPHeader))
 
 	server := &http.Server{
 		Addr:      ":" + PORT,
@@ -51,7 +179,15 @@ func main() {
 		TLSConfig: tlsConfig,
 	}
 
-	err = server.ListenAndServeTLS(cert, key)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if os.Getenv("PROXY_PROTOCOL") == "true" {
+		listener = &proxyproto.Listener{Listener: listener, Trusted: trustedProxies}
+	}
+
+	err = server.ServeTLS(listener, cert, key)
 
 	if err != nil {
 		log.Fatal("Error starting the server: ", err)
@@ -0,0 +1,289 @@
+// Command mock-oidc is a minimal OpenID Connect provider for developing and
+// testing single sign-on offline. It signs in whoever submits its login form
+// with the email and groups they typed in, so never expose it.
+//
+// Point the API at it with
+//
+//	OIDC_ISSUER=http://localhost:9000
+//	OIDC_CLIENT_ID=school-api
+//	OIDC_CLIENT_SECRET=school-api-secret
+//	OIDC_REDIRECT_URL=https://localhost:3000/auth/oidc/callback
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "mock-key-1"
+
+type authCode struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	groups        []string
+	expiresAt     time.Time
+}
+
+type issuer struct {
+	url          string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authCode
+}
+
+func main() {
+	addr := getenv("MOCK_OIDC_ADDR", ":9000")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	iss := &issuer{
+		url:          strings.TrimSuffix(getenv("MOCK_OIDC_ISSUER", "http://localhost:9000"), "/"),
+		clientID:     getenv("MOCK_OIDC_CLIENT_ID", "school-api"),
+		clientSecret: getenv("MOCK_OIDC_CLIENT_SECRET", "school-api-secret"),
+		key:          key,
+		codes:        make(map[string]authCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("GET /jwks", iss.jwks)
+	mux.HandleFunc("GET /authorize", iss.authorizeForm)
+	mux.HandleFunc("POST /authorize", iss.authorize)
+	mux.HandleFunc("POST /token", iss.token)
+
+	log.Println("Mock OIDC issuer", iss.url, "listening on", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+func (iss *issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                iss.url,
+		"authorization_endpoint":                iss.url + "/authorize",
+		"token_endpoint":                        iss.url + "/token",
+		"jwks_uri":                              iss.url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile", "groups"},
+	})
+}
+
+func (iss *issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Mock OIDC login</title>
+<h1>Mock OIDC login</h1>
+<form method="post">
+  {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+  {{end}}
+  <p><label>Email <input name="email" value="{{.Email}}" required></label></p>
+  <p><label>Groups (comma separated) <input name="groups" value="{{.Groups}}"></label></p>
+  <p><button>Sign in</button></p>
+</form>
+`))
+
+func (iss *issuer) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if msg := iss.checkAuthRequest(q); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	params := map[string]string{}
+	for _, name := range []string{"client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		params[name] = q.Get(name)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginForm.Execute(w, map[string]any{
+		"Params": params,
+		"Email":  q.Get("login_hint"),
+		"Groups": getenv("MOCK_OIDC_DEFAULT_GROUPS", ""),
+	})
+}
+
+func (iss *issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	if msg := iss.checkAuthRequest(r.PostForm); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var groups []string
+	for _, group := range strings.Split(r.PostForm.Get("groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	code := randomString()
+	iss.mu.Lock()
+	iss.codes[code] = authCode{
+		clientID:      r.PostForm.Get("client_id"),
+		redirectURI:   r.PostForm.Get("redirect_uri"),
+		codeChallenge: r.PostForm.Get("code_challenge"),
+		nonce:         r.PostForm.Get("nonce"),
+		email:         strings.TrimSpace(r.PostForm.Get("email")),
+		groups:        groups,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	iss.mu.Unlock()
+
+	redirect, _ := url.Parse(r.PostForm.Get("redirect_uri"))
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", r.PostForm.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (iss *issuer) checkAuthRequest(q url.Values) string {
+	switch {
+	case q.Get("client_id") != iss.clientID:
+		return "unknown client_id"
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		return "PKCE with S256 is required"
+	}
+	if u, err := url.Parse(q.Get("redirect_uri")); err != nil || !u.IsAbs() {
+		return "invalid redirect_uri"
+	}
+	return ""
+}
+
+func (iss *issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", "invalid form")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != iss.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(iss.clientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "")
+		return
+	}
+
+	iss.mu.Lock()
+	code, ok := iss.codes[r.PostForm.Get("code")]
+	delete(iss.codes, r.PostForm.Get("code"))
+	iss.mu.Unlock()
+
+	switch {
+	case !ok || time.Now().After(code.expiresAt) || code.clientID != clientID:
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	case code.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant", "redirect_uri mismatch")
+		return
+	case s256(r.PostForm.Get("code_verifier")) != code.codeChallenge:
+		tokenError(w, "invalid_grant", "code_verifier mismatch")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            iss.url,
+		"sub":            code.email,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          code.nonce,
+		"email":          code.email,
+		"email_verified": true,
+		"groups":         code.groups,
+	})
+	idToken.Header["kid"] = keyID
+
+	signed, err := idToken.SignedString(iss.key)
+	if err != nil {
+		tokenError(w, "server_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
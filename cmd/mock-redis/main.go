@@ -0,0 +1,45 @@
+// Command mock-redis is a stand-in for Redis to develop and test the shared
+// rate limit store offline. It is an in-memory Redis (miniredis) that runs
+// scripts such as ratelimit.TokenBucketScript through an embedded Lua
+// interpreter, so the store talks to it exactly as to a real server.
+//
+// Point the API at it with
+//
+//	RATE_LIMIT_STORE=redis
+//	RATE_LIMIT_REDIS_URL=redis://localhost:6380
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func main() {
+	addr := getenv("MOCK_REDIS_ADDR", ":6380")
+
+	srv := miniredis.NewMiniRedis()
+	if password := os.Getenv("MOCK_REDIS_PASSWORD"); password != "" {
+		srv.RequireAuth(password)
+	}
+	if err := srv.StartAddr(addr); err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Close()
+
+	log.Println("Mock Redis listening on", srv.Addr())
+
+	// miniredis only expires keys when told time has passed
+	for range time.Tick(time.Second) {
+		srv.FastForward(time.Second)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
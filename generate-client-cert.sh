@@ -0,0 +1,61 @@
+#!/bin/bash
+# Mints a client certificate for local mTLS testing, signed by a development
+# CA that is created on first use.
+#
+#   ./generate-client-cert.sh <common-name> [email]
+#
+# Point the server at the CA with TLS_CLIENT_CA_FILE=certs/client-ca.crt and
+# map the certificate in TLS_CLIENT_IDENTITIES, e.g. {"match": "cn:<common-name>", ...}.
+set -euo pipefail
+
+if [ $# -lt 1 ]; then
+  echo "usage: $0 <common-name> [email]" >&2
+  exit 1
+fi
+
+CN="$1"
+EMAIL="${2:-}"
+DIR=certs/clients
+
+mkdir -p "$DIR"
+
+if [ ! -f certs/client-ca.crt ]; then
+  openssl req -x509 -nodes -days 1825 \
+    -newkey rsa:2048 \
+    -keyout certs/client-ca.key \
+    -out certs/client-ca.crt \
+    -subj "/O=Localhost Testing/OU=Dev/CN=Local Client CA" \
+    -addext "basicConstraints=critical,CA:TRUE" \
+    -addext "keyUsage=critical,keyCertSign,cRLSign"
+fi
+
+SAN="DNS:${CN}"
+if [ -n "$EMAIL" ]; then
+  SAN="${SAN},email:${EMAIL}"
+fi
+
+EXT=$(mktemp)
+trap 'rm -f "$EXT"' EXIT
+cat > "$EXT" <<CONF
+basicConstraints = critical, CA:FALSE
+keyUsage = critical, digitalSignature, keyEncipherment
+extendedKeyUsage = clientAuth
+subjectAltName = ${SAN}
+CONF
+
+openssl req -new -nodes \
+  -newkey rsa:2048 \
+  -keyout "$DIR/$CN.key" \
+  -out "$DIR/$CN.csr" \
+  -subj "/O=Localhost Testing/OU=Dev/CN=${CN}"
+
+openssl x509 -req -days 365 \
+  -in "$DIR/$CN.csr" \
+  -CA certs/client-ca.crt \
+  -CAkey certs/client-ca.key \
+  -CAcreateserial \
+  -out "$DIR/$CN.crt" \
+  -extfile "$EXT"
+
+rm -f "$DIR/$CN.csr"
+echo "Created $DIR/$CN.crt and $DIR/$CN.key"
@@ -1,36 +1,292 @@
 package middleware
 
 import (
+	"bufio"
+	"compress/flate"
 	"compress/gzip"
-	"fmt"
+	"errors"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
+// CompressionOptions tunes response compression. Responses shorter than
+// MinSize are sent as they are, compressing them costs more than it saves.
+// SkipTypes are media types, or "type/*" families, that are compressed
+// already.
+type CompressionOptions struct {
+	MinSize   int
+	SkipTypes []string
+}
+
+var DefaultCompressionOptions = CompressionOptions{
+	MinSize: 1024,
+	SkipTypes: []string{
+		"image/*", "video/*", "audio/*", "font/woff", "font/woff2",
+		"application/zip", "application/gzip", "application/x-gzip",
+		"application/zstd", "application/x-bzip2", "application/x-7z-compressed",
+		"application/pdf", "application/octet-stream",
+	},
+}
+
+// Compression compresses responses with DefaultCompressionOptions.
 func Compression(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, r)
+	return CompressionWith(DefaultCompressionOptions)(next)
+}
+
+// CompressionWith negotiates zstd, br, gzip or deflate from Accept-Encoding,
+// honouring q-values and preferring that order on ties. The decision is
+// taken once MinSize bytes were written, the handler flushes or it
+// finishes, so handlers never need to know.
+func CompressionWith(options CompressionOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, options: options, encoding: encoding, status: http.StatusOK}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// encodings lists the supported codings, most preferred first.
+var encodings = []string{"zstd", "br", "gzip", "deflate"}
+
+// negotiateEncoding picks the coding with the highest q-value. It returns
+// "" for identity.
+func negotiateEncoding(acceptEncoding string) string {
+	q := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
 		}
 
-		// set response header
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
+		weight := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
 
-		// Wrap the ResponseWriter
-		w = &gzipRespWriter{ResponseWriter: w, Writer: gz}
+		if name == "*" {
+			wildcard = weight
+		} else if name == "x-gzip" {
+			q["gzip"] = weight
+		} else {
+			q[name] = weight
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range encodings {
+		weight, ok := q[name]
+		if !ok {
+			weight = wildcard
+		}
+		if weight > bestQ {
+			best, bestQ = name, weight
+		}
+	}
+	return best
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
 
-		next.ServeHTTP(w, r)
-		fmt.Println("Sent Response from Compression middleware")
-	})
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return gz
+	}},
+	"deflate": {New: func() any {
+		fl, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return fl
+	}},
+	"br": {New: func() any {
+		// level 4 keeps dynamic responses fast, 11 is for static assets
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	"zstd": {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return enc
+	}},
 }
 
-type gzipRespWriter struct {
+type compressWriter struct {
 	http.ResponseWriter
-	Writer *gzip.Writer
+	options  CompressionOptions
+	encoding string
+
+	status      int
+	wroteHeader bool
+	buf         []byte
+	decided     bool
+	enc         encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	// informational responses go out at once and do not end the header
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !cw.wroteHeader {
+		cw.status = status
+		cw.wroteHeader = true
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.options.MinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends the header, compressed if the response qualifies, and the
+// buffered start of the body. large reports whether the body reached
+// MinSize or is streamed.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	header := cw.Header()
+
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// what net/http would sniff, before the bytes become unrecognisable
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if large && cw.compressible() {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		// the compressed bytes differ from what a strong ETag promises
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		cw.enc = encoderPools[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	switch {
+	case cw.status < 200 || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified:
+		return false
+	case header.Get("Content-Encoding") != "":
+		return false
+	case strings.Contains(header.Get("Cache-Control"), "no-transform"):
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return true
+	}
+	family, _, _ := strings.Cut(mediaType, "/")
+	// SVG is text despite being an image
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	return !slices.Contains(cw.options.SkipTypes, mediaType) && !slices.Contains(cw.options.SkipTypes, family+"/*")
+}
+
+// Flush sends what there is, compressed if the response qualifies, so
+// streaming responses are not held back until MinSize.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response once the handler returned.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// nothing written, let net/http send its empty 200
+			cw.decided = true
+			return nil
+		}
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+	cw.enc.Reset(nil)
+	encoderPools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+	return err
+}
+
+// Hijack hands the connection over for protocol upgrades, nothing may have
+// been written yet.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if cw.decided {
+		return nil, nil, errors.New("compression: response already started")
+	}
+	cw.decided = true
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
 }
 
-func (g *gzipRespWriter) Write(b []byte) (int, error) {
-	return g.Writer.Write(b)
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
 }
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DecompressionOptions caps request bodies. MaxSize applies to the body
+// both as sent and once decompressed, a few kilobytes of gzip can expand
+// to gigabytes.
+type DecompressionOptions struct {
+	MaxSize int64
+}
+
+var DefaultDecompressionOptions = DecompressionOptions{
+	MaxSize: 10 << 20,
+}
+
+// Decompression decodes request bodies with DefaultDecompressionOptions.
+func Decompression(next http.Handler) http.Handler {
+	return DecompressionWith(DefaultDecompressionOptions)(next)
+}
+
+// DecompressionWith decodes request bodies sent with Content-Encoding gzip,
+// deflate, br or zstd, so handlers and the HPP middleware read plain bytes.
+// The body is decoded up front to answer oversized ones with 413 instead of
+// a decoding error halfway through the handler. Unknown codings get 415.
+// Plain bodies are capped at MaxSize as well, reading past it fails with an
+// *http.MaxBytesError.
+func DecompressionWith(options DecompressionOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > options.MaxSize {
+				utils.WriteProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes.", options.MaxSize))
+				return
+			}
+
+			codings := contentCodings(r.Header.Get("Content-Encoding"))
+			if len(codings) == 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, options.MaxSize)
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, coding := range codings {
+				if !slices.Contains(encodings, coding) {
+					w.Header().Set("Accept-Encoding", strings.Join(encodings, ", "))
+					utils.WriteProblem(w, r, http.StatusUnsupportedMediaType, fmt.Sprintf("Content-Encoding %s is not supported.", coding))
+					return
+				}
+			}
+
+			body, err := decodeBody(http.MaxBytesReader(w, r.Body, options.MaxSize), codings, options.MaxSize)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				utils.WriteProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes.", options.MaxSize))
+				return
+			} else if err != nil {
+				utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid compressed request body.")
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			r.Header.Del("Content-Encoding")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// contentCodings lists the codings in the order they were applied,
+// leaving out identity.
+func contentCodings(contentEncoding string) []string {
+	var codings []string
+	for _, coding := range strings.Split(contentEncoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "", "identity":
+			continue
+		case "x-gzip":
+			coding = "gzip"
+		}
+		codings = append(codings, coding)
+	}
+	return codings
+}
+
+// decodeBody undoes the codings last to first and reads at most maxSize
+// decompressed bytes.
+func decodeBody(body io.ReadCloser, codings []string, maxSize int64) ([]byte, error) {
+	defer body.Close()
+
+	var r io.Reader = body
+	for i := len(codings) - 1; i >= 0; i-- {
+		dec, err := newDecoder(codings[i], r)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		r = dec
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, &http.MaxBytesError{Limit: maxSize}
+	}
+	return data, nil
+}
+
+func newDecoder(coding string, r io.Reader) (io.ReadCloser, error) {
+	switch coding {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// deflate is meant to be zlib wrapped, but raw streams are common
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		// the window bounds the decoder's memory whatever the frame claims
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(8<<20))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported content coding %s", coding)
+}
+
+// isZlibHeader checks for compression method 8 and a valid header checksum,
+// see RFC 1950.
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
@@ -0,0 +1,136 @@
+// Package authz decides what an authenticated principal may do.
+//
+// Permissions are "resource:action" strings. Each role is granted a set of
+// permissions, every grant limited to a scope: all records, the principal's
+// own record, or the records of the principal's class.
+package authz
+
+import (
+	"slices"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+type Scope int
+
+const (
+	ScopeNone Scope = iota
+	ScopeOwn
+	ScopeClass
+	ScopeAll
+)
+
+const (
+	TeachersRead   = "teachers:read"
+	TeachersCreate = "teachers:create"
+	TeachersUpdate = "teachers:update"
+	TeachersDelete = "teachers:delete"
+	StudentsRead   = "students:read"
+	StudentsUpdate = "students:update"
+	ExecsRead      = "execs:read"
+	TimetableRead  = "timetable:read"
+	TimetableWrite = "timetable:write"
+	AccountsRead   = "accounts:read"
+	AccountsWrite  = "accounts:write"
+	ApiKeysManage  = "api_keys:manage"
+	SecurityManage = "security:manage"
+	AuditRead      = "audit:read"
+	TenantsManage  = "tenants:manage"
+)
+
+// Permissions lists every permission, e.g. to validate API key scopes.
+var Permissions = []string{
+	TeachersRead, TeachersCreate, TeachersUpdate, TeachersDelete,
+	StudentsRead, StudentsUpdate, ExecsRead,
+	TimetableRead, TimetableWrite,
+	AccountsRead, AccountsWrite, ApiKeysManage, SecurityManage, AuditRead,
+	TenantsManage,
+}
+
+const (
+	RoleExec    = "exec"
+	RoleTeacher = "teacher"
+	RoleStudent = "student"
+	// RoleApiKey principals are machine clients limited to their key scopes.
+	RoleApiKey = "api_key"
+	// RoleClientCert principals are machine clients authenticated by a TLS
+	// client certificate, limited to the scopes of their identity.
+	RoleClientCert = "client_cert"
+)
+
+// rolePolicies lists the grants of every role. Execs administer everything.
+var rolePolicies = map[string]map[string]Scope{
+	RoleTeacher: {
+		TeachersRead:   ScopeAll,
+		TeachersUpdate: ScopeOwn,
+		StudentsRead:   ScopeClass,
+		StudentsUpdate: ScopeClass,
+		TimetableRead:  ScopeAll,
+	},
+	RoleStudent: {
+		StudentsRead:  ScopeOwn,
+		TimetableRead: ScopeClass,
+	},
+}
+
+// ScopeOf returns how far permission reaches for p. ScopeNone means the
+// permission is not granted at all.
+func ScopeOf(p *utils.Principal, permission string) Scope {
+	if p == nil {
+		return ScopeNone
+	}
+	if p.Role == RoleExec {
+		return ScopeAll
+	}
+	if p.Role == RoleApiKey || p.Role == RoleClientCert {
+		if slices.Contains(p.Scopes, permission) {
+			return ScopeAll
+		}
+		return ScopeNone
+	}
+	return rolePolicies[p.Role][permission]
+}
+
+func Allowed(p *utils.Principal, permission string) bool {
+	return ScopeOf(p, permission) != ScopeNone
+}
+
+// CanAccessTeacher checks permission against a single teacher record.
+func CanAccessTeacher(p *utils.Principal, permission string, teacherId int) bool {
+	switch ScopeOf(p, permission) {
+	case ScopeAll:
+		return true
+	case ScopeOwn:
+		return p.TeacherID != 0 && p.TeacherID == teacherId
+	}
+	return false
+}
+
+// CanAccessStudent checks permission against a single student record.
+func CanAccessStudent(p *utils.Principal, permission string, studentId int, class string) bool {
+	switch ScopeOf(p, permission) {
+	case ScopeAll:
+		return true
+	case ScopeClass:
+		return p.Class != "" && p.Class == class
+	case ScopeOwn:
+		return p.StudentID != 0 && p.StudentID == studentId
+	}
+	return false
+}
+
+// CanAccessClass checks permission against everything belonging to a class.
+func CanAccessClass(p *utils.Principal, permission string, class string) bool {
+	switch ScopeOf(p, permission) {
+	case ScopeAll:
+		return true
+	case ScopeClass:
+		return p.Class != "" && p.Class == class
+	}
+	return false
+}
+
+// Forbidden is the error returned when a principal is denied.
+func Forbidden(permission string) error {
+	return &utils.AppError{Kind: utils.KindForbidden, Msg: "Missing permission " + permission + "."}
+}
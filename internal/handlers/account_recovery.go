@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/mailer"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// Mailer delivers account emails. It is replaced at startup with the sender
+// configured through MAIL_DRIVER.
+var Mailer mailer.Sender = mailer.LogSender{}
+
+var errTooManyTokens = errors.New("too many tokens requested")
+
+const (
+	passwordResetTTL = time.Hour
+	emailVerifyTTL   = 24 * time.Hour
+)
+
+// ForgotPassword emails a reset link. It cannot be used to discover
+// accounts: the lookup, the token and the mail all happen after the
+// response, which is the same whether or not the account exists.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "email is required.")
+		return
+	}
+
+	// keeps the tenant of the request but outlives it
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		account, err := sqlconnect.GetAccountByEmailDB(ctx, req.Email)
+		if err == nil {
+			err = sendAccountToken(ctx, account, sqlconnect.TokenPurposePasswordReset, passwordResetTTL, "/reset-password",
+				"Reset your password",
+				"Someone asked to reset the password of your account %s.\n\nOpen this link within an hour to choose a new password:\n%s\n\nIf this was not you, ignore this email.")
+		}
+		if err != nil && !errors.Is(err, utils.ErrNotFound) && !errors.Is(err, errTooManyTokens) {
+			log.Println("password reset:", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+	}{
+		Status: "If the account exists, a reset link has been sent.",
+	})
+}
+
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Token == "" {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "token and password are required.")
+		return
+	}
+
+	fieldErrors := utils.ValidatePartial(models.Account{}, map[string]any{"password": req.Password}, "")
+	if len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	passwordHash, err := utils.HashPassword(req.Password)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error hashing password."))
+		return
+	}
+
+	err = sqlconnect.ResetPasswordDB(r.Context(), utils.HashToken(req.Token), passwordHash)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequestEmailVerification emails a verification link to the caller.
+func RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	account, err := sqlconnect.GetAccountByIdDB(r.Context(), utils.PrincipalFrom(r).AccountID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if account.EmailVerifiedAt != nil {
+		utils.WriteProblem(w, r, http.StatusConflict, "Email is already verified.")
+		return
+	}
+
+	err = sendAccountToken(r.Context(), account, sqlconnect.TokenPurposeEmailVerify, emailVerifyTTL, "/verify-email",
+		"Verify your email address",
+		"Confirm that this address belongs to your account %s by opening:\n%s")
+	if errors.Is(err, errTooManyTokens) {
+		utils.WriteProblem(w, r, http.StatusTooManyRequests, "Too many verification emails requested, try again later.")
+		return
+	} else if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Token == "" {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "token is required.")
+		return
+	}
+
+	err = sqlconnect.VerifyEmailDB(r.Context(), utils.HashToken(req.Token))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendAccountToken issues a single-use token and mails a link containing it.
+// Every account may only request ACCOUNT_TOKEN_HOURLY_LIMIT tokens of one
+// purpose per hour (default 3).
+func sendAccountToken(ctx context.Context, account models.Account, purpose string, ttl time.Duration, path, subject, bodyFormat string) error {
+	limit := utils.IntFromEnv("ACCOUNT_TOKEN_HOURLY_LIMIT", 3)
+
+	count, err := sqlconnect.CountRecentAccountTokensDB(ctx, account.ID, purpose, time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if count >= limit {
+		log.Printf("account %d exceeded the %s token limit", account.ID, purpose)
+		return errTooManyTokens
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error generating token.")
+	}
+
+	err = sqlconnect.AddAccountTokenDB(ctx, account.ID, purpose, utils.HashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://localhost:3000"
+	}
+	link := baseURL + path + "?token=" + url.QueryEscape(token)
+
+	msg := mailer.Message{
+		To:      account.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf(bodyFormat, account.Username, link),
+	}
+
+	// sent in the background, SMTP round trips would hold the response
+	go func() {
+		if err := Mailer.Send(msg); err != nil {
+			log.Println(err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+func GetAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := sqlconnect.GetAccountsDB(r.Context())
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	resp := struct {
+		Status string           `json:"status"`
+		Count  int              `json:"count"`
+		Data   []models.Account `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(accounts),
+		Data:   accounts,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+var errExecAccount = &utils.AppError{Kind: utils.KindForbidden, Msg: "Only administrators may create administrator accounts."}
+
+// AddAccount creates an account, by default an exec. Exec accounts hold
+// every permission, so only execs may create them, not API keys or client
+// certificates granted accounts:write.
+func AddAccount(w http.ResponseWriter, r *http.Request) {
+	var account models.Account
+	err := json.NewDecoder(r.Body).Decode(&account)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "invalid request Body")
+		return
+	}
+
+	if account.Role == "" {
+		account.Role = authz.RoleExec
+	}
+
+	if account.Role == authz.RoleExec && utils.PrincipalFrom(r).Role != authz.RoleExec {
+		utils.WriteError(w, r, errExecAccount)
+		return
+	}
+
+	fieldErrors := utils.ValidateStruct(account)
+	if account.Role == authz.RoleTeacher && account.TeacherID == nil {
+		fieldErrors = append(fieldErrors, utils.FieldError{Field: "$.teacher_id", Message: "is required for teacher accounts"})
+	}
+	if account.Role == authz.RoleStudent && account.StudentID == nil {
+		fieldErrors = append(fieldErrors, utils.FieldError{Field: "$.student_id", Message: "is required for student accounts"})
+	}
+	if len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	account.PasswordHash, err = utils.HashPassword(account.Password)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error hashing password."))
+		return
+	}
+
+	addedAccount, err := sqlconnect.AddAccountDB(r.Context(), account)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(addedAccount)
+}
+
+// UnlockAccount lifts a login lockout of the account before it runs out.
+func UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid account ID")
+		return
+	}
+
+	account, err := sqlconnect.GetAccountByIdDB(r.Context(), id)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	unlocked, err := sqlconnect.ClearLoginFailuresDB(r.Context(), userSubject(account.Username))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if unlocked {
+		audit(r, "account_unlocked", &account.ID, userSubject(account.Username)+" unlocked")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+func GetApiKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := sqlconnect.GetApiKeysDB(r.Context())
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	resp := struct {
+		Status string          `json:"status"`
+		Count  int             `json:"count"`
+		Data   []models.ApiKey `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(keys),
+		Data:   keys,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// AddApiKey creates a key and returns its secret. The secret is not stored
+// and cannot be retrieved again.
+func AddApiKey(w http.ResponseWriter, r *http.Request) {
+	var key models.ApiKey
+	err := json.NewDecoder(r.Body).Decode(&key)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "invalid request Body")
+		return
+	}
+
+	fieldErrors := utils.ValidateStruct(key)
+	if len(key.Scopes) == 0 {
+		fieldErrors = append(fieldErrors, utils.FieldError{Field: "$.scopes", Message: "is required"})
+	}
+	for i, scope := range key.Scopes {
+		if !slices.Contains(authz.Permissions, scope) {
+			fieldErrors = append(fieldErrors, utils.FieldError{Field: fmt.Sprintf("$.scopes[%d]", i), Message: "is not a known permission"})
+		} else if scope == authz.ApiKeysManage || scope == authz.SecurityManage || scope == authz.TenantsManage || scope == authz.AccountsWrite {
+			// keys must not be able to mint further keys, relax login security,
+			// create schools or create accounts, execs may do all of that
+			fieldErrors = append(fieldErrors, utils.FieldError{Field: fmt.Sprintf("$.scopes[%d]", i), Message: "cannot be granted to API keys"})
+		}
+	}
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()) {
+		fieldErrors = append(fieldErrors, utils.FieldError{Field: "$.expires_at", Message: "must be in the future"})
+	}
+	if len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	prefix, err := utils.RandomToken(4)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error generating API key."))
+		return
+	}
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error generating API key."))
+		return
+	}
+
+	key.AccountID = utils.PrincipalFrom(r).AccountID
+	key.Prefix = prefix
+	key.Key = models.ApiKeyPrefix + prefix + "_" + secret
+	key.KeyHash = utils.HashToken(key.Key)
+
+	addedKey, err := sqlconnect.AddApiKeyDB(r.Context(), key)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(addedKey)
+}
+
+func RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	err = sqlconnect.RevokeApiKeyDB(r.Context(), id)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string `json:"status"`
+		ID     int    `json:"id"`
+	}{
+		Status: "API key revoked.",
+		ID:     id,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
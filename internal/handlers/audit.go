@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// audit records event for accountId, with the caller of r as the actor.
+// Failures are only logged, auditing must not break the request.
+func audit(r *http.Request, event string, accountId *int, detail string) {
+	entry := models.AuditEvent{
+		Event:     event,
+		AccountID: accountId,
+		IP:        utils.ClientIP(r),
+		Detail:    detail,
+	}
+	if principal := utils.PrincipalFrom(r); principal != nil {
+		entry.ActorAccountID = &principal.AccountID
+	}
+
+	log.Printf("audit: %s %s", event, detail)
+	err := sqlconnect.AddAuditEventDB(r.Context(), entry)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	events, err := sqlconnect.GetAuditEventsDB(r)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	resp := struct {
+		Status string              `json:"status"`
+		Count  int                 `json:"count"`
+		Data   []models.AuditEvent `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(events),
+		Data:   events,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// dummyPasswordHash is verified against when the username does not exist so
+// that unknown and known usernames take the same time to reject.
+var dummyPasswordHash, _ = utils.HashPassword("not-a-real-password")
+
+type tokenResponse struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func Login(w http.ResponseWriter, r *http.Request) {
+	if !checkCookieLoginContentType(w, r) {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Username == "" || req.Password == "" {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Username and password are required.")
+		return
+	}
+
+	if !checkLoginLock(w, r, req.Username) {
+		return
+	}
+
+	account, err := sqlconnect.GetAccountByUsernameDB(r.Context(), req.Username)
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if err != nil {
+		utils.VerifyPassword(req.Password, dummyPasswordHash)
+		recordLoginFailure(r, req.Username, nil)
+		utils.WriteProblem(w, r, http.StatusUnauthorized, "Invalid username or password.")
+		return
+	}
+	if !utils.VerifyPassword(req.Password, account.PasswordHash) {
+		recordLoginFailure(r, req.Username, &account.ID)
+		utils.WriteProblem(w, r, http.StatusUnauthorized, "Invalid username or password.")
+		return
+	}
+
+	completeLogin(w, r, account)
+}
+
+// completeLogin continues a login whose first factor checked out. It asks
+// for the second factor or an enrollment where needed, otherwise it opens
+// the session.
+func completeLogin(w http.ResponseWriter, r *http.Request, account models.Account) {
+	if account.TOTPEnabledAt != nil {
+		writeMFAChallenge(w, r, account.ID, mfaPurposeVerify)
+		return
+	}
+
+	required, err := sqlconnect.IsTwoFactorRequiredDB(r.Context(), account.Role)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if required {
+		writeMFAChallenge(w, r, account.ID, mfaPurposeEnroll)
+		return
+	}
+
+	clearLoginFailures(r.Context(), account.Username)
+	issueCredentials(w, r, account)
+}
+
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "refresh_token is required.")
+		return
+	}
+
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error generating token."))
+		return
+	}
+
+	account, err := sqlconnect.RotateRefreshTokenDB(r.Context(), utils.HashToken(req.RefreshToken), utils.HashToken(refreshToken), refreshTokenExpiry())
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	// sessions opened before the role started to require two-factor
+	// authentication end here
+	if account.TOTPEnabledAt == nil {
+		required, err := sqlconnect.IsTwoFactorRequiredDB(r.Context(), account.Role)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if required {
+			utils.WriteProblem(w, r, http.StatusForbidden, "Two-factor authentication must be enabled, log in again to set it up.")
+			return
+		}
+	}
+
+	tokens, err := newTokens(r.Context(), account, refreshToken)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	writeJSONNoStore(w, tokens)
+}
+
+// Logout ends a cookie session, or revokes the access token used for the
+// request and, when given, the refresh token of the same session.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	principal := utils.PrincipalFrom(r)
+	if principal.SessionID != 0 {
+		err := sqlconnect.RevokeSessionDB(r.Context(), principal.SessionID)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		utils.ClearSessionCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if principal.TokenID == "" {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Only bearer token and cookie sessions can be logged out.")
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid request payload.")
+		return
+	}
+
+	err = sqlconnect.RevokeAccessTokenDB(r.Context(), principal.TokenID, principal.ExpiresAt)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if req.RefreshToken != "" {
+		err = sqlconnect.RevokeRefreshTokenDB(r.Context(), principal.AccountID, utils.HashToken(req.RefreshToken))
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startSession opens a new refresh token family for account.
+func startSession(ctx context.Context, account models.Account) (tokenResponse, error) {
+	familyId, err := utils.RandomToken(16)
+	if err != nil {
+		return tokenResponse{}, utils.ErrorHandler(err, "Error generating token.")
+	}
+
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return tokenResponse{}, utils.ErrorHandler(err, "Error generating token.")
+	}
+
+	err = sqlconnect.AddRefreshTokenDB(ctx, account.ID, utils.HashToken(refreshToken), familyId, refreshTokenExpiry())
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return newTokens(ctx, account, refreshToken)
+}
+
+func newTokens(ctx context.Context, account models.Account, refreshToken string) (tokenResponse, error) {
+	principal, err := sqlconnect.AccountPrincipalDB(ctx, account)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	accessToken, claims, err := utils.SignAccessToken(principal)
+	if err != nil {
+		return tokenResponse{}, utils.ErrorHandler(err, "Error signing access token.")
+	}
+
+	return tokenResponse{
+		TokenType:    "Bearer",
+		AccessToken:  accessToken,
+		ExpiresIn:    int(time.Until(claims.ExpiresAt.Time).Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// writeJSONNoStore writes a response carrying credentials, which must never
+// be cached.
+func writeJSONNoStore(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+func refreshTokenExpiry() time.Time {
+	return time.Now().Add(utils.DurationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour))
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/oidc"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// OIDC is the single sign-on provider, nil unless OIDC_ISSUER and friends
+// are configured.
+var OIDC *oidc.Provider
+
+const (
+	oidcStateCookie  = "oidc_state"
+	oidcTenantCookie = "oidc_tenant"
+	oidcLoginTTL     = 10 * time.Minute
+)
+
+// OIDCLogin starts a single sign-on login and redirects to the provider.
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if OIDC == nil {
+		utils.WriteProblem(w, r, http.StatusNotFound, "Single sign-on is not configured.")
+		return
+	}
+
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error generating state."))
+		return
+	}
+
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error generating nonce."))
+		return
+	}
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error generating code verifier."))
+		return
+	}
+
+	authURL, err := OIDC.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		utils.WriteError(w, r, utils.UnavailableError(err, "Single sign-on provider is unavailable."))
+		return
+	}
+
+	err = sqlconnect.AddOIDCLoginDB(r.Context(), utils.HashToken(state), nonce, verifier, time.Now().Add(oidcLoginTTL))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	// binds the login to this browser so a victim cannot be made to
+	// complete an attacker's login
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// the provider redirects back without the X-Tenant-ID header the login
+	// may have named its tenant in
+	if slug := utils.TenantSlug(r.Context()); slug != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcTenantCookie,
+			Value:    slug,
+			Path:     "/auth/oidc",
+			MaxAge:   int(oidcLoginTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	if r.URL.Query().Get("session") == "cookie" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcSessionCookie,
+			Value:    "cookie",
+			Path:     "/auth/oidc",
+			MaxAge:   int(oidcLoginTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a single sign-on login. The provider's ID token
+// is mapped to an account, which then continues like a password login.
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if OIDC == nil {
+		utils.WriteProblem(w, r, http.StatusNotFound, "Single sign-on is not configured.")
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		utils.WriteProblem(w, r, http.StatusUnauthorized, "Single sign-on failed: "+errCode+" "+query.Get("error_description"))
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Login state does not match, start the login again.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true, Secure: true})
+	http.SetCookie(w, &http.Cookie{Name: oidcSessionCookie, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true, Secure: true})
+	http.SetCookie(w, &http.Cookie{Name: oidcTenantCookie, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true, Secure: true})
+
+	r, err = oidcLoginTenant(r)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "code is required.")
+		return
+	}
+
+	nonce, verifier, err := sqlconnect.ConsumeOIDCLoginDB(r.Context(), utils.HashToken(state))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	claims, err := OIDC.Exchange(r.Context(), code, verifier, nonce)
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusUnauthorized, "Single sign-on failed.")
+		return
+	}
+
+	account, err := oidcAccount(r, claims)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	completeLogin(w, r, account)
+}
+
+// oidcLoginTenant puts the tenant the login was started in back on the
+// callback request, where only a tenant subdomain survives the redirect.
+// The login state and the account live in that tenant's database.
+func oidcLoginTenant(r *http.Request) (*http.Request, error) {
+	var slug string
+	if cookie, err := r.Cookie(oidcTenantCookie); err == nil {
+		slug = cookie.Value
+	}
+
+	if current := utils.TenantSlug(r.Context()); current != "" || slug == "" {
+		if current != slug {
+			return r, &utils.AppError{Kind: utils.KindValidation, Msg: "Login was started for another tenant, start the login again."}
+		}
+		return r, nil
+	}
+
+	tenant, err := sqlconnect.GetTenantBySlugDB(r.Context(), slug)
+	if err != nil {
+		return r, err
+	}
+	return r.WithContext(utils.WithTenant(r.Context(), &utils.Tenant{Slug: tenant.Slug, Database: tenant.DBName})), nil
+}
+
+// oidcAccount finds or creates the account of a single sign-on user. The
+// role follows from the groups claim: OIDC_EXEC_GROUPS and
+// OIDC_TEACHER_GROUPS list the groups granting exec and teacher access.
+// Teachers are linked to the teacher record with the same email.
+func oidcAccount(r *http.Request, claims *oidc.IDClaims) (models.Account, error) {
+	if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+		return models.Account{}, &utils.AppError{Kind: utils.KindForbidden, Msg: "The provider did not confirm an email address."}
+	}
+
+	role := oidcRole(claims.Groups)
+	if role == "" {
+		return models.Account{}, &utils.AppError{Kind: utils.KindForbidden, Msg: "Your groups do not grant access to this application."}
+	}
+
+	account, err := sqlconnect.GetAccountByEmailDB(r.Context(), claims.Email)
+	if err == nil {
+		// never let group membership change the role of an existing account
+		if account.Role != role {
+			return models.Account{}, &utils.AppError{Kind: utils.KindForbidden, Msg: "Your groups do not match the role of your account."}
+		}
+		return account, nil
+	} else if !errors.Is(err, utils.ErrNotFound) {
+		return models.Account{}, err
+	}
+
+	account = models.Account{
+		Username: claims.Email,
+		Email:    claims.Email,
+		Role:     role,
+	}
+
+	if role == authz.RoleTeacher {
+		teacher, err := Teachers.GetTeacherByEmail(r.Context(), claims.Email)
+		if errors.Is(err, utils.ErrNotFound) {
+			return models.Account{}, &utils.AppError{Kind: utils.KindForbidden, Msg: "No teacher record matches your email."}
+		} else if err != nil {
+			return models.Account{}, err
+		}
+		account.TeacherID = &teacher.ID
+	}
+
+	// single sign-on accounts get a random password nobody knows, a reset
+	// email is needed to ever log in with a password
+	password, err := utils.RandomToken(32)
+	if err != nil {
+		return models.Account{}, utils.ErrorHandler(err, "Error generating password.")
+	}
+	account.PasswordHash, err = utils.HashPassword(password)
+	if err != nil {
+		return models.Account{}, utils.ErrorHandler(err, "Error hashing password.")
+	}
+
+	account, err = sqlconnect.AddAccountDB(r.Context(), account)
+	if err != nil {
+		return models.Account{}, err
+	}
+	audit(r, "account_provisioned", &account.ID, "created from single sign-on as "+role)
+
+	return sqlconnect.GetAccountByIdDB(r.Context(), account.ID)
+}
+
+func oidcRole(groups []string) string {
+	for _, candidate := range []struct{ role, env string }{
+		{authz.RoleExec, "OIDC_EXEC_GROUPS"},
+		{authz.RoleTeacher, "OIDC_TEACHER_GROUPS"},
+	} {
+		for _, group := range strings.Split(os.Getenv(candidate.env), ",") {
+			group = strings.TrimSpace(group)
+			if group != "" && slices.Contains(groups, group) {
+				return candidate.role
+			}
+		}
+	}
+	return ""
+}
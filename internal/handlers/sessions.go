@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// oidcSessionCookie remembers across the provider redirect that a single
+// sign-on login asked for a cookie session.
+const oidcSessionCookie = "oidc_session"
+
+type sessionResponse struct {
+	Status      string    `json:"status"`
+	CSRFToken   string    `json:"csrf_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	IdleTimeout int       `json:"idle_timeout"`
+}
+
+// wantsCookieSession reports whether a login asked for a cookie session
+// with ?session=cookie instead of bearer tokens.
+func wantsCookieSession(r *http.Request) bool {
+	if r.URL.Query().Get("session") == "cookie" {
+		return true
+	}
+	cookie, err := r.Cookie(oidcSessionCookie)
+	return err == nil && cookie.Value == "cookie"
+}
+
+// checkCookieLoginContentType refuses cookie session logins that are not
+// sent as application/json. Browsers post text/plain and form bodies cross
+// site without a preflight, which would sign the victim's browser into the
+// attacker's account (login CSRF); a JSON body needs the CORS preflight.
+func checkCookieLoginContentType(w http.ResponseWriter, r *http.Request) bool {
+	if !wantsCookieSession(r) {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		utils.WriteProblem(w, r, http.StatusUnsupportedMediaType, "Cookie session logins must be sent as application/json.")
+		return false
+	}
+	return true
+}
+
+// issueCredentials opens the session of a completed login, a cookie session
+// for browsers that asked for one and a refresh token family otherwise.
+func issueCredentials(w http.ResponseWriter, r *http.Request, account models.Account) {
+	if wantsCookieSession(r) {
+		session, err := startCookieSession(w, r, account)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		writeJSONNoStore(w, session)
+		return
+	}
+
+	tokens, err := startSession(r.Context(), account)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	writeJSONNoStore(w, tokens)
+}
+
+// startCookieSession sets the cookie of a new session. It always issues a
+// new session ID and ends the session the browser had before, so a planted
+// cookie never becomes authenticated.
+func startCookieSession(w http.ResponseWriter, r *http.Request, account models.Account) (sessionResponse, error) {
+	if old, err := r.Cookie(utils.SessionCookie); err == nil && old.Value != "" {
+		if err := sqlconnect.RevokeSessionByTokenDB(r.Context(), utils.HashToken(old.Value)); err != nil {
+			log.Println(err)
+		}
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return sessionResponse{}, utils.ErrorHandler(err, "Error generating session.")
+	}
+
+	csrfToken, err := utils.RandomToken(32)
+	if err != nil {
+		return sessionResponse{}, utils.ErrorHandler(err, "Error generating CSRF token.")
+	}
+
+	session, err := sqlconnect.AddSessionDB(r.Context(), utils.HashToken(token), models.Session{
+		AccountID: account.ID,
+		CSRFToken: csrfToken,
+		ExpiresAt: time.Now().Add(utils.SessionAbsoluteTimeout()),
+	})
+	if err != nil {
+		return sessionResponse{}, err
+	}
+
+	utils.SetSessionCookie(w, token, session.ExpiresAt)
+	return sessionResponse{
+		Status:      "authenticated",
+		CSRFToken:   session.CSRFToken,
+		ExpiresAt:   session.ExpiresAt,
+		IdleTimeout: int(utils.SessionIdleTimeout().Seconds()),
+	}, nil
+}
+
+// GetSession lets browser clients pick up the CSRF token again, e.g. after
+// a page reload.
+func GetSession(w http.ResponseWriter, r *http.Request) {
+	principal := utils.PrincipalFrom(r)
+	if principal.SessionID == 0 {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "The request was not made with a session cookie.")
+		return
+	}
+
+	writeJSONNoStore(w, struct {
+		AccountID int    `json:"account_id"`
+		Username  string `json:"username"`
+		Role      string `json:"role"`
+		CSRFToken string `json:"csrf_token"`
+	}{
+		AccountID: principal.AccountID,
+		Username:  principal.Username,
+		Role:      principal.Role,
+		CSRFToken: principal.CSRFToken,
+	})
+}
@@ -1,7 +1,102 @@
 package handlers
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
 
-func StudentsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("Hello from the students route"))
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// GetStudents lists the students the caller may read: all of them, those of
+// their class for teachers, or their own record for students.
+func GetStudents(w http.ResponseWriter, r *http.Request) {
+	students, err := sqlconnect.GetStudentsDB(r)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	resp := struct {
+		Status string           `json:"status"`
+		Count  int              `json:"count"`
+		Data   []models.Student `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(students),
+		Data:   students,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func GetStudent(w http.ResponseWriter, r *http.Request) {
+	student, ok := accessibleStudent(w, r, authz.StudentsRead)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(student)
+}
+
+// PatchStudent lets teachers edit the students of their class. Moving a
+// student to another class takes full update scope, a teacher could
+// otherwise hand students over to, or take them from, another class.
+func PatchStudent(w http.ResponseWriter, r *http.Request) {
+	student, ok := accessibleStudent(w, r, authz.StudentsUpdate)
+	if !ok {
+		return
+	}
+
+	var updates map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid request payload.")
+		return
+	}
+
+	if fieldErrors := utils.ValidatePartial(models.Student{}, updates, "", "id"); len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	if _, ok := updates["class"]; ok && authz.ScopeOf(utils.PrincipalFrom(r), authz.StudentsUpdate) != authz.ScopeAll {
+		utils.WriteError(w, r, &utils.AppError{Kind: utils.KindForbidden, Msg: "Only administrators may change the class of a student."})
+		return
+	}
+
+	updatedStudent, err := sqlconnect.PatchStudentDB(r.Context(), student, updates)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedStudent)
+}
+
+// accessibleStudent loads the student of the path and checks permission
+// against them, writing the error response when that fails.
+func accessibleStudent(w http.ResponseWriter, r *http.Request, permission string) (models.Student, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid student ID.")
+		return models.Student{}, false
+	}
+
+	student, err := sqlconnect.GetStudentByIdDB(r.Context(), id)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return models.Student{}, false
+	}
+
+	if !authz.CanAccessStudent(utils.PrincipalFrom(r), permission, student.ID, student.Class) {
+		utils.WriteError(w, r, authz.Forbidden(permission))
+		return models.Student{}, false
+	}
+	return student, true
 }
@@ -7,22 +7,48 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
 	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository"
 	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
 )
 
+// Teachers is the teacher repository. It is wrapped at startup with the
+// read-through cache when REPOSITORY_CACHE_SIZE is set.
+var Teachers repository.TeacherRepository = sqlconnect.TeacherRepo{}
+
+// The class of a teacher decides which students and timetables they reach,
+// see AccountPrincipalDB, so teachers editing their own record must not
+// change their class or subject.
+var errTeacherAssignment = &utils.AppError{Kind: utils.KindForbidden, Msg: "Only administrators may change the class or subject of a teacher."}
+
+func canChangeTeacherAssignment(p *utils.Principal, updates map[string]any) bool {
+	if authz.ScopeOf(p, authz.TeachersUpdate) == authz.ScopeAll {
+		return true
+	}
+	_, class := updates["class"]
+	_, subject := updates["subject"]
+	return !class && !subject
+}
+
 func AddTeacher(w http.ResponseWriter, r *http.Request) {
 
 	var newTeachers []models.Teacher
 	err := json.NewDecoder(r.Body).Decode(&newTeachers)
 	if err != nil {
-		http.Error(w, "invalid request Body", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "invalid request Body")
 		return
 	}
 
-	addedTeachers, err := sqlconnect.AddTeacherToDB(newTeachers)
+	if fieldErrors := utils.ValidateSlice(newTeachers); len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	addedTeachers, err := Teachers.AddTeachers(r.Context(), newTeachers)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, r, err)
 		return
 	}
 
@@ -45,10 +71,9 @@ func AddTeacher(w http.ResponseWriter, r *http.Request) {
 
 func GetTeachers(w http.ResponseWriter, r *http.Request) {
 
-	var teachers []models.Teacher
-	teachers, err := sqlconnect.GetTeachersDB(teachers, r)
+	teachers, err := Teachers.GetTeachers(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, r, err)
 		return
 	}
 
@@ -74,13 +99,13 @@ func GetTeacher(w http.ResponseWriter, r *http.Request) {
 	idNum, err := strconv.Atoi(idStr)
 	if err != nil {
 		fmt.Println(err)
-		http.Error(w, "Invalid ID.", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid ID.")
 		return
 	}
 
-	teacher, err := sqlconnect.GetTeacherByIdDB(idNum)
+	teacher, err := Teachers.GetTeacherById(r.Context(), idNum)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, r, err)
 		return
 	}
 
@@ -93,21 +118,48 @@ func UpdateTeacher(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid teacher ID", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher ID")
+		return
+	}
+
+	if !authz.CanAccessTeacher(utils.PrincipalFrom(r), authz.TeachersUpdate, id) {
+		utils.WriteError(w, r, authz.Forbidden(authz.TeachersUpdate))
 		return
 	}
 
 	var updatedTeacher models.Teacher
 
 	err = json.NewDecoder(r.Body).Decode(&updatedTeacher)
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid request payload.")
+		return
+	}
+
+	if fieldErrors := utils.ValidateStruct(updatedTeacher); len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	if authz.ScopeOf(utils.PrincipalFrom(r), authz.TeachersUpdate) != authz.ScopeAll {
+		existing, err := Teachers.GetTeacherById(r.Context(), id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if existing.Class != updatedTeacher.Class || existing.Subject != updatedTeacher.Subject {
+			utils.WriteError(w, r, errTeacherAssignment)
+			return
+		}
+	}
 
-	updatedTeacherFromDB, err := sqlconnect.UpdateTeacherDB(id, updatedTeacher)
+	updatedTeacherFromDB, err := Teachers.UpdateTeacher(r.Context(), id, updatedTeacher)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Conent-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedTeacherFromDB)
 }
 
@@ -116,21 +168,41 @@ func PatchTeacher(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid teacher ID", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher ID")
+		return
+	}
+
+	if !authz.CanAccessTeacher(utils.PrincipalFrom(r), authz.TeachersUpdate, id) {
+		utils.WriteError(w, r, authz.Forbidden(authz.TeachersUpdate))
 		return
 	}
 
 	var updates map[string]any
 
 	err = json.NewDecoder(r.Body).Decode(&updates)
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid request payload.")
+		return
+	}
 
-	updatedTeacher, err := sqlconnect.PatchSingleTeacherDB(id, updates)
+	if fieldErrors := utils.ValidatePartial(models.Teacher{}, updates, "", "id"); len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	if !canChangeTeacherAssignment(utils.PrincipalFrom(r), updates) {
+		utils.WriteError(w, r, errTeacherAssignment)
+		return
+	}
+
+	updatedTeacher, err := Teachers.PatchTeacher(r.Context(), id, updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Conent-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedTeacher)
 
 }
@@ -141,13 +213,40 @@ func PatchTeachers(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid request payload.", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid request payload.")
+		return
+	}
+
+	principal := utils.PrincipalFrom(r)
+	var fieldErrors []utils.FieldError
+	for i, update := range updates {
+		path := fmt.Sprintf("$[%d]", i)
+		id, validId := utils.JSONID(update["id"])
+		if _, ok := update["id"]; !ok {
+			fieldErrors = append(fieldErrors, utils.FieldError{Field: path + ".id", Message: "is required"})
+		} else if !validId {
+			fieldErrors = append(fieldErrors, utils.FieldError{Field: path + ".id", Message: "must be an integer"})
+		}
+		if authz.ScopeOf(principal, authz.TeachersUpdate) != authz.ScopeAll {
+			if !validId || !authz.CanAccessTeacher(principal, authz.TeachersUpdate, id) {
+				utils.WriteError(w, r, authz.Forbidden(authz.TeachersUpdate))
+				return
+			}
+		}
+		if !canChangeTeacherAssignment(principal, update) {
+			utils.WriteError(w, r, errTeacherAssignment)
+			return
+		}
+		fieldErrors = append(fieldErrors, utils.ValidatePartial(models.Teacher{}, update, path, "id")...)
+	}
+	if len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
 		return
 	}
 
-	err = sqlconnect.PatchMultipleTeachersDB(updates)
+	err = Teachers.PatchTeachers(r.Context(), updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, r, err)
 		return
 	}
 
@@ -161,13 +260,13 @@ func DeleteTeacher(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid teacher ID", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher ID")
 		return
 	}
 
-	err = sqlconnect.DeleteSingleTeacherDB(id)
+	err = Teachers.DeleteTeacher(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, r, err)
 		return
 	}
 
@@ -189,13 +288,13 @@ func DeleteTeachers(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&ids)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	deletedIds, err := sqlconnect.DeleteMultipleTeachersDB(ids)
+	deletedIds, err := Teachers.DeleteTeachers(r.Context(), ids)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteError(w, r, err)
 		return
 	}
 
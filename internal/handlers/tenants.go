@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// errTenantsFromTenant stops the execs of one school from seeing or creating
+// the others. Tenants are managed by the execs of the default tenant.
+var errTenantsFromTenant = &utils.AppError{Kind: utils.KindForbidden, Msg: "Tenants can only be managed from the default tenant."}
+
+func GetTenants(w http.ResponseWriter, r *http.Request) {
+	if utils.TenantFrom(r.Context()) != nil {
+		utils.WriteError(w, r, errTenantsFromTenant)
+		return
+	}
+
+	tenants, err := sqlconnect.GetTenantsDB(r.Context())
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	resp := struct {
+		Status string          `json:"status"`
+		Count  int             `json:"count"`
+		Data   []models.Tenant `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(tenants),
+		Data:   tenants,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type addTenantRequest struct {
+	models.Tenant
+	// Exec is the first account of the new school, created as an exec.
+	Exec *models.Account `json:"exec,omitempty"`
+}
+
+// AddTenant provisions a school: its database, schema and optionally its
+// first exec account.
+func AddTenant(w http.ResponseWriter, r *http.Request) {
+	if utils.TenantFrom(r.Context()) != nil {
+		utils.WriteError(w, r, errTenantsFromTenant)
+		return
+	}
+
+	var req addTenantRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "invalid request Body")
+		return
+	}
+
+	fieldErrors := utils.ValidateStruct(req.Tenant)
+	if req.Exec != nil {
+		req.Exec.Role = authz.RoleExec
+		for _, fieldError := range utils.ValidateStruct(req.Exec) {
+			fieldError.Field = "$.exec" + fieldError.Field[1:]
+			fieldErrors = append(fieldErrors, fieldError)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	if req.Exec != nil {
+		req.Exec.PasswordHash, err = utils.HashPassword(req.Exec.Password)
+		if err != nil {
+			utils.WriteError(w, r, utils.ErrorHandler(err, "Error hashing password."))
+			return
+		}
+	}
+
+	// the exec is created with the school, a school without one is rolled
+	// back
+	tenant, err := sqlconnect.AddTenantDB(r.Context(), req.Tenant, req.Exec)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	audit(r, "tenant_created", nil, "tenant "+tenant.Slug+" in database "+tenant.DBName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tenant)
+}
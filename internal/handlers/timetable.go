@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+type timetableResponse struct {
+	Status string                 `json:"status"`
+	Count  int                    `json:"count"`
+	Data   []models.TimetableSlot `json:"data"`
+}
+
+func GetTimetable(w http.ResponseWriter, r *http.Request) {
+	slots, err := sqlconnect.GetTimetableDB(r)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timetableResponse{Status: "success", Count: len(slots), Data: slots})
+}
+
+func AddTimetableSlots(w http.ResponseWriter, r *http.Request) {
+	var newSlots []models.TimetableSlot
+	err := json.NewDecoder(r.Body).Decode(&newSlots)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "invalid request Body")
+		return
+	}
+
+	addedSlots, err := sqlconnect.AddTimetableSlotsDB(r.Context(), newSlots)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(timetableResponse{Status: "success", Count: len(addedSlots), Data: addedSlots})
+}
+
+func DeleteTimetableSlot(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid timetable slot ID")
+		return
+	}
+
+	err = sqlconnect.DeleteTimetableSlotDB(r.Context(), id)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string `json:"status"`
+		ID     int    `json:"id"`
+	}{
+		Status: "Timetable slot deleted.",
+		ID:     id,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func GetTeacherTimetable(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher ID")
+		return
+	}
+
+	if authz.ScopeOf(utils.PrincipalFrom(r), authz.TimetableRead) != authz.ScopeAll {
+		utils.WriteError(w, r, authz.Forbidden(authz.TimetableRead))
+		return
+	}
+
+	slots, err := sqlconnect.GetTeacherTimetableDB(r.Context(), id)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timetableResponse{Status: "success", Count: len(slots), Data: slots})
+}
+
+func GetClassTimetable(w http.ResponseWriter, r *http.Request) {
+	class := r.PathValue("id")
+	if !authz.CanAccessClass(utils.PrincipalFrom(r), authz.TimetableRead, class) {
+		utils.WriteError(w, r, authz.Forbidden(authz.TimetableRead))
+		return
+	}
+
+	slots, err := sqlconnect.GetClassTimetableDB(r.Context(), class)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timetableResponse{Status: "success", Count: len(slots), Data: slots})
+}
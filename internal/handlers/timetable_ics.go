@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/ical"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+func GetTeacherTimetableICS(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher ID")
+		return
+	}
+
+	if authz.ScopeOf(utils.PrincipalFrom(r), authz.TimetableRead) != authz.ScopeAll {
+		utils.WriteError(w, r, authz.Forbidden(authz.TimetableRead))
+		return
+	}
+
+	slots, err := sqlconnect.GetTeacherTimetableDB(r.Context(), id)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	writeTimetableICS(w, r, fmt.Sprintf("Teacher %d timetable", id), fmt.Sprintf("teacher-%d", id), slots)
+}
+
+func GetClassTimetableICS(w http.ResponseWriter, r *http.Request) {
+	class := r.PathValue("id")
+	if !authz.CanAccessClass(utils.PrincipalFrom(r), authz.TimetableRead, class) {
+		utils.WriteError(w, r, authz.Forbidden(authz.TimetableRead))
+		return
+	}
+
+	slots, err := sqlconnect.GetClassTimetableDB(r.Context(), class)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	writeTimetableICS(w, r, fmt.Sprintf("Class %s timetable", class), "class-"+class, slots)
+}
+
+func writeTimetableICS(w http.ResponseWriter, r *http.Request, name, filename string, slots []models.TimetableSlot) {
+	termStart, termEnd, err := termBoundaries()
+	if err != nil {
+		log.Println(err)
+		utils.WriteProblem(w, r, http.StatusInternalServerError, "Invalid term configuration.")
+		return
+	}
+
+	cal := ical.Calendar{
+		Name:     name,
+		TimeZone: os.Getenv("SCHOOL_TIMEZONE"),
+	}
+
+	for _, slot := range slots {
+		event, err := timetableEvent(utils.TenantSlug(r.Context()), slot, termStart, termEnd)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		cal.Events = append(cal.Events, event)
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ics"`, safeFilename(filename)))
+	cal.WriteTo(w)
+}
+
+// safeFilename replaces everything but [A-Za-z0-9._-], class names come from
+// the path and must not break out of the quoted header value.
+func safeFilename(name string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-' {
+			return c
+		}
+		return '_'
+	}, name)
+}
+
+// timetableEvent turns a slot into a weekly event whose first occurrence is
+// the slot's weekday on or after the term start and which stops recurring at
+// the end of the term. Slot IDs of different tenants overlap, so the UID
+// names the tenant; the default tenant keeps the UIDs it always had.
+func timetableEvent(tenant string, slot models.TimetableSlot, termStart, termEnd time.Time) (ical.Event, error) {
+	startClock, err := time.Parse("15:04", slot.StartTime)
+	if err != nil {
+		return ical.Event{}, fmt.Errorf("slot %d: invalid start time %q", slot.ID, slot.StartTime)
+	}
+	endClock, err := time.Parse("15:04", slot.EndTime)
+	if err != nil {
+		return ical.Event{}, fmt.Errorf("slot %d: invalid end time %q", slot.ID, slot.EndTime)
+	}
+
+	// time.Weekday counts from Sunday, slots use ISO weekdays
+	offset := (int(time.Weekday(slot.Weekday%7)) - int(termStart.Weekday()) + 7) % 7
+	day := termStart.AddDate(0, 0, offset)
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), startClock.Hour(), startClock.Minute(), 0, 0, time.UTC)
+	end := time.Date(day.Year(), day.Month(), day.Day(), endClock.Hour(), endClock.Minute(), 0, 0, time.UTC)
+
+	var until time.Time
+	if !termEnd.IsZero() {
+		until = time.Date(termEnd.Year(), termEnd.Month(), termEnd.Day(), 23, 59, 59, 0, time.UTC)
+	}
+
+	uid := fmt.Sprintf("timetable-slot-%d@golang-basic-crud-api", slot.ID)
+	if tenant != "" {
+		uid = fmt.Sprintf("timetable-slot-%d-%s@golang-basic-crud-api", slot.ID, tenant)
+	}
+
+	return ical.Event{
+		UID:         uid,
+		Summary:     fmt.Sprintf("%s (%s)", slot.Course, slot.Class),
+		Description: fmt.Sprintf("Period %d, teacher %d", slot.Period, slot.TeacherID),
+		Location:    slot.Room,
+		Start:       start,
+		End:         end,
+		RRule:       ical.WeeklyRRule(start, until),
+	}, nil
+}
+
+// defaultTermStart anchors the weekly events when TERM_START is unset. It is
+// fixed, an anchor moving with every fetch would move the events in
+// subscribed calendars on every refresh.
+var defaultTermStart = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// termBoundaries reads TERM_START and TERM_END (YYYY-MM-DD). Without a term
+// start the calendar starts from defaultTermStart, without a term end it is
+// open ended.
+func termBoundaries() (time.Time, time.Time, error) {
+	var termStart, termEnd time.Time
+	var err error
+
+	if v := os.Getenv("TERM_START"); v != "" {
+		termStart, err = time.Parse(time.DateOnly, v)
+		if err != nil {
+			return termStart, termEnd, fmt.Errorf("invalid TERM_START: %w", err)
+		}
+	} else {
+		termStart = defaultTermStart
+	}
+
+	if v := os.Getenv("TERM_END"); v != "" {
+		termEnd, err = time.Parse(time.DateOnly, v)
+		if err != nil {
+			return termStart, termEnd, fmt.Errorf("invalid TERM_END: %w", err)
+		}
+		if termEnd.Before(termStart) {
+			return termStart, termEnd, fmt.Errorf("TERM_END %s is before TERM_START", v)
+		}
+	}
+
+	return termStart, termEnd, nil
+}
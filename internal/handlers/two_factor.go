@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+const (
+	mfaPurposeVerify = "verify"
+	mfaPurposeEnroll = "enroll"
+
+	recoveryCodeCount = 10
+)
+
+var errInvalidSecondFactor = &utils.AppError{Kind: utils.KindUnauthorized, Msg: "Invalid two-factor code."}
+
+type mfaChallenge struct {
+	Status    string `json:"status"`
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// writeMFAChallenge answers a login whose password was correct but that still
+// needs a second factor ("verify") or a two-factor enrollment ("enroll").
+func writeMFAChallenge(w http.ResponseWriter, r *http.Request, accountId int, purpose string) {
+	token, err := utils.SignMFAToken(accountId, purpose, utils.TenantSlug(r.Context()))
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error signing token."))
+		return
+	}
+
+	status := "mfa_required"
+	if purpose == mfaPurposeEnroll {
+		status = "mfa_enrollment_required"
+	}
+
+	writeJSONNoStore(w, mfaChallenge{
+		Status:    status,
+		MFAToken:  token,
+		ExpiresIn: int((5 * time.Minute).Seconds()),
+	})
+}
+
+// LoginSecondFactor completes a login with a TOTP code or a recovery code.
+func LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	if !checkCookieLoginContentType(w, r) {
+		return
+	}
+
+	var req struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "mfa_token and code or recovery_code are required.")
+		return
+	}
+
+	accountId, err := utils.ParseMFAToken(req.MFAToken, mfaPurposeVerify, utils.TenantSlug(r.Context()))
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusUnauthorized, "Invalid or expired mfa_token.")
+		return
+	}
+
+	account, err := sqlconnect.GetAccountByIdDB(r.Context(), accountId)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if !checkLoginLock(w, r, account.Username) {
+		return
+	}
+
+	err = verifySecondFactor(r.Context(), account, req.Code, req.RecoveryCode)
+	if errors.Is(err, errInvalidSecondFactor) {
+		recordLoginFailure(r, account.Username, &account.ID)
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	clearLoginFailures(r.Context(), account.Username)
+	issueCredentials(w, r, account)
+}
+
+// EnrollTwoFactor creates a new TOTP secret for the caller. It stays inactive
+// until ConfirmTwoFactor receives a code generated from it. Signed-in callers
+// confirm their password, a stolen token alone must not bind a second factor
+// of the thief's choosing; an enrollment mfa_token was issued for a correct
+// password already.
+func EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid request payload.")
+		return
+	}
+
+	account, err := twoFactorAccount(r, req.MFAToken)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if account.TOTPEnabledAt != nil {
+		utils.WriteProblem(w, r, http.StatusConflict, "Two-factor authentication is already enabled.")
+		return
+	}
+
+	if req.MFAToken == "" {
+		if req.Password == "" {
+			utils.WriteProblem(w, r, http.StatusBadRequest, "password is required.")
+			return
+		}
+		if !checkLoginLock(w, r, account.Username) {
+			return
+		}
+		if !utils.VerifyPassword(req.Password, account.PasswordHash) {
+			recordLoginFailure(r, account.Username, &account.ID)
+			utils.WriteProblem(w, r, http.StatusUnauthorized, "Invalid password.")
+			return
+		}
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error generating secret."))
+		return
+	}
+
+	sealed, err := utils.EncryptSecret(secret)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error encrypting secret."))
+		return
+	}
+
+	err = sqlconnect.StartTOTPEnrollmentDB(r.Context(), account.ID, sealed)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "golang-basic-crud-api"
+	}
+
+	writeJSONNoStore(w, struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OtpauthURI: utils.TOTPURI(issuer, account.Username, secret),
+	})
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the first code
+// checks out and hands out the recovery codes, which are shown only once.
+// Logins that were held back for enrollment receive their tokens here.
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !checkCookieLoginContentType(w, r) {
+		return
+	}
+
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "code is required.")
+		return
+	}
+
+	account, err := twoFactorAccount(r, req.MFAToken)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if account.TOTPEnabledAt != nil {
+		utils.WriteProblem(w, r, http.StatusConflict, "Two-factor authentication is already enabled.")
+		return
+	}
+
+	secret, err := totpSecret(r.Context(), account.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if secret == "" {
+		utils.WriteProblem(w, r, http.StatusConflict, "Start the enrollment first.")
+		return
+	}
+
+	step, ok := utils.VerifyTOTP(secret, req.Code, time.Now())
+	if !ok {
+		utils.WriteError(w, r, errInvalidSecondFactor)
+		return
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		utils.WriteError(w, r, utils.ErrorHandler(err, "Error generating recovery codes."))
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(code)
+	}
+
+	err = sqlconnect.EnableTOTPDB(r.Context(), account.ID, step, hashes)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	resp := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+		*tokenResponse
+		*sessionResponse
+	}{
+		RecoveryCodes: codes,
+	}
+
+	if req.MFAToken != "" && wantsCookieSession(r) {
+		session, err := startCookieSession(w, r, account)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		resp.sessionResponse = &session
+	} else if req.MFAToken != "" {
+		tokens, err := startSession(r.Context(), account)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		resp.tokenResponse = &tokens
+	}
+
+	writeJSONNoStore(w, resp)
+}
+
+// DisableTwoFactor turns two-factor authentication off after checking a
+// current code, unless the security policy of the role requires it.
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "code or recovery_code is required.")
+		return
+	}
+
+	account, err := twoFactorAccount(r, "")
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if account.TOTPEnabledAt == nil {
+		utils.WriteProblem(w, r, http.StatusConflict, "Two-factor authentication is not enabled.")
+		return
+	}
+
+	required, err := sqlconnect.IsTwoFactorRequiredDB(r.Context(), account.Role)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if required {
+		utils.WriteProblem(w, r, http.StatusForbidden, "Two-factor authentication is required for this role.")
+		return
+	}
+
+	err = verifySecondFactor(r.Context(), account, req.Code, req.RecoveryCode)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	err = sqlconnect.DisableTOTPDB(r.Context(), account.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetSecurityPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := sqlconnect.GetSecurityPoliciesDB(r.Context())
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	resp := struct {
+		Status string                  `json:"status"`
+		Count  int                     `json:"count"`
+		Data   []models.SecurityPolicy `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(policies),
+		Data:   policies,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UpdateSecurityPolicy sets whether a role must use two-factor
+// authentication. Accounts of the role without it are sent through the
+// enrollment on their next login.
+func UpdateSecurityPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.SecurityPolicy
+	err := json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "invalid request Body")
+		return
+	}
+
+	fieldErrors := utils.ValidateStruct(policy)
+	if len(fieldErrors) > 0 {
+		utils.WriteError(w, r, utils.InvalidFieldsError(fieldErrors))
+		return
+	}
+
+	err = sqlconnect.SetSecurityPolicyDB(r.Context(), policy)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// twoFactorAccount returns the account managing its two-factor settings:
+// the signed-in caller, or the holder of an enrollment mfa_token.
+func twoFactorAccount(r *http.Request, mfaToken string) (models.Account, error) {
+	if mfaToken != "" {
+		accountId, err := utils.ParseMFAToken(mfaToken, mfaPurposeEnroll, utils.TenantSlug(r.Context()))
+		if err != nil {
+			return models.Account{}, &utils.AppError{Kind: utils.KindUnauthorized, Msg: "Invalid or expired mfa_token."}
+		}
+		return sqlconnect.GetAccountByIdDB(r.Context(), accountId)
+	}
+
+	// API keys act for an account but must not change how it logs in
+	principal := utils.PrincipalFrom(r)
+	if principal == nil || (principal.TokenID == "" && principal.SessionID == 0) {
+		return models.Account{}, &utils.AppError{Kind: utils.KindUnauthorized, Msg: "A bearer token, session or mfa_token is required."}
+	}
+	return sqlconnect.GetAccountByIdDB(r.Context(), principal.AccountID)
+}
+
+// verifySecondFactor accepts a TOTP code that was not used before or an
+// unused recovery code, which is consumed.
+func verifySecondFactor(ctx context.Context, account models.Account, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		ok, err := sqlconnect.UseRecoveryCodeDB(ctx, account.ID, utils.HashToken(strings.ToLower(strings.TrimSpace(recoveryCode))))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	secret, err := totpSecret(ctx, account.ID)
+	if err != nil {
+		return err
+	}
+	// no code checks out without a secret, not even one computed under an
+	// empty key
+	if secret == "" {
+		return errInvalidSecondFactor
+	}
+
+	step, ok := utils.VerifyTOTP(secret, code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+
+	fresh, err := sqlconnect.UseTOTPStepDB(ctx, account.ID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+func totpSecret(ctx context.Context, accountId int) (string, error) {
+	sealed, err := sqlconnect.GetTOTPSecretDB(ctx, accountId)
+	if err != nil || sealed == "" {
+		return "", err
+	}
+
+	secret, stale, err := utils.DecryptSecret(sealed)
+	if err != nil {
+		return "", utils.ErrorHandler(err, "Error decrypting TOTP secret.")
+	}
+
+	// sealed under TOTP_ENCRYPTION_KEY_PREVIOUS, moved to the current key so
+	// the previous one can be dropped; failing that is retried next login
+	if stale {
+		if resealed, err := utils.EncryptSecret(secret); err == nil {
+			sqlconnect.ResealTOTPSecretDB(ctx, accountId, sealed, resealed)
+		}
+	}
+	return secret, nil
+}
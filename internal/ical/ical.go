@@ -0,0 +1,108 @@
+// Package ical renders RFC 5545 iCalendar documents.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	localTimeFormat = "20060102T150405"
+	utcTimeFormat   = "20060102T150405Z"
+	maxLineOctets   = 75
+)
+
+// Event is a VEVENT. Start and End are written as floating local times, so
+// they are shown in the wall-clock time of the school regardless of the
+// viewer's time zone.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	RRule       string
+}
+
+type Calendar struct {
+	Name     string
+	TimeZone string
+	Events   []Event
+}
+
+// WeeklyRRule returns a weekly recurrence on the weekday of start. A zero
+// until leaves the recurrence open ended.
+func WeeklyRRule(start, until time.Time) string {
+	days := []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+	rule := "FREQ=WEEKLY;BYDAY=" + days[start.Weekday()]
+	if !until.IsZero() {
+		rule += ";UNTIL=" + until.Format(localTimeFormat)
+	}
+	return rule
+}
+
+func (c Calendar) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	stamp := time.Now().UTC().Format(utcTimeFormat)
+
+	writeLine(&buf, "BEGIN:VCALENDAR")
+	writeLine(&buf, "VERSION:2.0")
+	writeLine(&buf, "PRODID:-//golang-basic-crud-api//timetable//EN")
+	writeLine(&buf, "CALSCALE:GREGORIAN")
+	writeLine(&buf, "METHOD:PUBLISH")
+	if c.Name != "" {
+		writeLine(&buf, "X-WR-CALNAME:"+escapeText(c.Name))
+	}
+	if c.TimeZone != "" {
+		writeLine(&buf, "X-WR-TIMEZONE:"+c.TimeZone)
+	}
+
+	for _, e := range c.Events {
+		writeLine(&buf, "BEGIN:VEVENT")
+		writeLine(&buf, "UID:"+e.UID)
+		writeLine(&buf, "DTSTAMP:"+stamp)
+		writeLine(&buf, "DTSTART:"+e.Start.Format(localTimeFormat))
+		writeLine(&buf, "DTEND:"+e.End.Format(localTimeFormat))
+		if e.RRule != "" {
+			writeLine(&buf, "RRULE:"+e.RRule)
+		}
+		writeLine(&buf, "SUMMARY:"+escapeText(e.Summary))
+		if e.Location != "" {
+			writeLine(&buf, "LOCATION:"+escapeText(e.Location))
+		}
+		if e.Description != "" {
+			writeLine(&buf, "DESCRIPTION:"+escapeText(e.Description))
+		}
+		writeLine(&buf, "END:VEVENT")
+	}
+
+	writeLine(&buf, "END:VCALENDAR")
+	return buf.WriteTo(w)
+}
+
+func escapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// writeLine folds content lines longer than 75 octets as required by
+// RFC 5545 section 3.1, never splitting a multi-byte character.
+func writeLine(buf *bytes.Buffer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		fmt.Fprintf(buf, "%s\r\n ", line[:cut])
+		line = line[cut:]
+		// continuation lines start with a space that counts towards the limit
+		limit = maxLineOctets - 1
+	}
+	buf.WriteString(line + "\r\n")
+}
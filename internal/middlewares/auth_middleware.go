@@ -0,0 +1,205 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/mtls"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// ClientCertIdentities maps verified TLS client certificates to principals.
+// It is nil unless TLS_CLIENT_IDENTITIES is configured.
+var ClientCertIdentities *mtls.Identities
+
+// publicRoutes can be called without credentials. Keys are either a path or
+// "METHOD path".
+var publicRoutes = map[string]bool{
+	"/":                    true,
+	"POST /auth/login":     true,
+	"POST /auth/login/2fa": true,
+	"POST /auth/refresh":   true,
+
+	"GET /auth/oidc/login":    true,
+	"GET /auth/oidc/callback": true,
+
+	"POST /auth/password/forgot": true,
+	"POST /auth/password/reset":  true,
+	"POST /auth/email/verify":    true,
+
+	// two-factor enrollment also accepts the mfa_token of a login that is
+	// waiting for it
+	"POST /auth/2fa/enroll":  true,
+	"POST /auth/2fa/confirm": true,
+}
+
+func isPublicRoute(r *http.Request) bool {
+	return publicRoutes[r.URL.Path] || publicRoutes[r.Method+" "+r.URL.Path]
+}
+
+// Authenticate verifies the bearer access token, API key, TLS client
+// certificate or session cookie of every non-public request and puts the
+// caller on the request context. Public routes still pick up valid
+// credentials but never reject a request.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicRoute(r) {
+			if principal, err := requestPrincipal(r); err == nil && principal != nil && validCSRF(r, principal) {
+				r = r.WithContext(utils.WithPrincipal(r.Context(), principal))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := requestPrincipal(r)
+		if principal == nil && err == nil {
+			unauthorized(w, r, "Missing bearer token or API key.")
+			return
+		}
+
+		if errors.Is(err, utils.ErrUnauthorized) {
+			unauthorized(w, r, err.Error())
+			return
+		} else if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if !validCSRF(r, principal) {
+			utils.WriteProblem(w, r, http.StatusForbidden, "Missing or invalid CSRF token.")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(utils.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// requestPrincipal resolves the Authorization header and falls back to the
+// client certificate, then the session cookie. It returns nil and no error
+// when the request carries no credentials. Credentials of another tenant
+// are rejected, client certificates only map to the default tenant.
+func requestPrincipal(r *http.Request) (*utils.Principal, error) {
+	principal, err := credentialsPrincipal(r)
+	if err != nil || principal == nil {
+		return principal, err
+	}
+	if principal.Tenant != utils.TenantSlug(r.Context()) {
+		return nil, invalidCredentials("Credentials were issued for another tenant.")
+	}
+	return principal, nil
+}
+
+func credentialsPrincipal(r *http.Request) (*utils.Principal, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	switch {
+	case strings.EqualFold(scheme, "Bearer") && token != "":
+		return bearerPrincipal(r.Context(), token)
+	case strings.EqualFold(scheme, "ApiKey") && token != "":
+		return apiKeyPrincipal(r.Context(), token)
+	}
+
+	if principal := certPrincipal(r); principal != nil {
+		return principal, nil
+	}
+	return sessionPrincipal(r)
+}
+
+// certPrincipal maps the client certificate, but only when the handshake
+// verified it against the client CA bundle.
+func certPrincipal(r *http.Request) *utils.Principal {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return ClientCertIdentities.Principal(r.TLS.VerifiedChains[0][0])
+}
+
+func bearerPrincipal(ctx context.Context, token string) (*utils.Principal, error) {
+	claims, err := utils.ParseAccessToken(token)
+	if err != nil {
+		return nil, invalidCredentials("Invalid or expired token.")
+	}
+
+	accountId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, invalidCredentials("Invalid or expired token.")
+	}
+
+	revoked, err := sqlconnect.IsAccessTokenRevokedDB(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, invalidCredentials("Token has been revoked.")
+	}
+
+	return &utils.Principal{
+		AccountID: accountId,
+		Username:  claims.Username,
+		Role:      claims.Role,
+		TeacherID: claims.TeacherID,
+		StudentID: claims.StudentID,
+		Class:     claims.Class,
+		TokenID:   claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+		Tenant:    claims.Tenant,
+	}, nil
+}
+
+// apiKeyPrincipal resolves "sk_<prefix>_<secret>" keys. The prefix selects
+// the stored key and the hash of the whole key must match.
+func apiKeyPrincipal(ctx context.Context, token string) (*utils.Principal, error) {
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(token, models.ApiKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(token, models.ApiKeyPrefix) {
+		return nil, invalidCredentials("Invalid API key.")
+	}
+
+	key, err := sqlconnect.GetApiKeyByPrefixDB(ctx, prefix)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, invalidCredentials("Invalid API key.")
+	} else if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(utils.HashToken(token))) != 1 {
+		return nil, invalidCredentials("Invalid API key.")
+	}
+	if key.RevokedAt != nil {
+		return nil, invalidCredentials("API key has been revoked.")
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, invalidCredentials("API key has expired.")
+	}
+
+	err = sqlconnect.TouchApiKeyDB(ctx, key.ID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return &utils.Principal{
+		AccountID: key.AccountID,
+		Username:  "api-key:" + key.Name,
+		Role:      authz.RoleApiKey,
+		APIKeyID:  key.ID,
+		Scopes:    key.Scopes,
+		Tenant:    utils.TenantSlug(ctx),
+	}, nil
+}
+
+func invalidCredentials(msg string) error {
+	return &utils.AppError{Kind: utils.KindUnauthorized, Msg: msg}
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="api"`)
+	w.Header().Add("WWW-Authenticate", `ApiKey realm="api"`)
+	utils.WriteProblem(w, r, http.StatusUnauthorized, detail)
+}
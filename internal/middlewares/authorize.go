@@ -0,0 +1,22 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// RequirePermission rejects callers that are not granted permission at any
+// scope. Record level checks are left to the handlers.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authz.Allowed(utils.PrincipalFrom(r), permission) {
+				utils.WriteError(w, r, authz.Forbidden(permission))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
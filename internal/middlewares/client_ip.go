@@ -0,0 +1,109 @@
+package middlewares
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// ClientIPHeaders are the headers proxies name the client in.
+var ClientIPHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+
+// ParseClientIPHeader returns the canonical name of one of ClientIPHeaders.
+func ParseClientIPHeader(name string) (string, error) {
+	for _, header := range ClientIPHeaders {
+		if strings.EqualFold(name, header) {
+			return header, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported client IP header %q, use one of %s", name, strings.Join(ClientIPHeaders, ", "))
+}
+
+// ClientIP resolves the address of the client behind trusted proxies and
+// puts it on the request context, where utils.ClientIP reads it. Only
+// header, the one the proxies set, is read, and only when the immediate
+// peer is in trusted. The other headers pass through from the client
+// untouched by most proxies and are ignored. The forwarding chain is walked
+// from the nearest hop back and the first address that is not a trusted
+// proxy is the client, so clients cannot spoof their address by sending the
+// header themselves.
+func ClientIP(trusted []netip.Prefix, header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := utils.PeerIP(r)
+			if peer, err := netip.ParseAddr(ip); err == nil && utils.PrefixesContain(trusted, peer) {
+				ip = forwardedClient(r, header, peer, trusted).String()
+			}
+			next.ServeHTTP(w, r.WithContext(utils.WithClientIP(r.Context(), ip)))
+		})
+	}
+}
+
+func forwardedClient(r *http.Request, header string, peer netip.Addr, trusted []netip.Prefix) netip.Addr {
+	var hops []string
+	switch header {
+	case "Forwarded":
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	case "X-Forwarded-For":
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	case "X-Real-IP":
+		// a single value set by the nearest proxy, which may be overwritten
+		// but not appended to
+		if values := r.Header.Values("X-Real-IP"); len(values) > 0 {
+			hops = values[len(values)-1:]
+		}
+	}
+
+	client := peer.Unmap()
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// an unparsable hop cannot be trusted, nor anything before it
+			break
+		}
+		client = addr
+		if !utils.PrefixesContain(trusted, addr) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded headers.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop accepts 192.0.2.1, 192.0.2.1:80, 2001:db8::1 and [2001:db8::1]:80.
+// Obfuscated and "unknown" identifiers are rejected.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if addr, err := netip.ParseAddr(strings.Trim(hop, "[]")); err == nil {
+		return addr.Unmap(), true
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
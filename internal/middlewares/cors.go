@@ -1,42 +1,169 @@
 package middlewares
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-var allowedOrigins = []string{
-	"https://my-origin-ulr.com",
-	"https://localhost:3000",
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// CORSPolicy decides which browser origins may call the API. Origins are
+// exact ("https://app.example.org"), wildcard subdomains
+// ("https://*.example.org", which does not match the bare domain) or "*"
+// for any origin.
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORSRoute overrides the default policy for the routes matching Pattern,
+// a path or "METHOD path" as in RateLimitRule. The first match wins.
+type CORSRoute struct {
+	Pattern string
+	Policy  CORSPolicy
 }
 
-func Cors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
+type CORSOptions struct {
+	Default CORSPolicy
+	Routes  []CORSRoute
+}
 
-		if isOriginAllowed(origin) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			http.Error(w, "Not allowed by CORS.", http.StatusForbidden)
-			return
+// Validate rejects policies that allow any origin with credentials: every
+// website could then call the API with the user's cookies and read the
+// responses.
+func (options CORSOptions) Validate() error {
+	policies := []CORSPolicy{options.Default}
+	for _, route := range options.Routes {
+		policies = append(policies, route.Policy)
+	}
+	for _, policy := range policies {
+		if policy.AllowCredentials && slices.Contains(policy.AllowedOrigins, "*") {
+			return errors.New("CORS: allowed origin \"*\" cannot be combined with credentials, list the origins")
 		}
+	}
+	return nil
+}
 
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Expose-Headers", "Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Max-Age", "3600")
+// Cors answers preflight requests itself with 204 and adds the CORS headers
+// to the responses of allowed origins. Requests of other origins are
+// refused, requests without an Origin header, which do not come from a
+// browser's cross-origin call, pass through untouched. options should pass
+// Validate.
+func Cors(options CORSOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
 
-		if r.Method == http.MethodOptions {
-			return
-		}
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			requestMethod := r.Header.Get("Access-Control-Request-Method")
+			preflight := r.Method == http.MethodOptions && requestMethod != ""
+
+			method := r.Method
+			if preflight {
+				method = requestMethod
+			}
+			policy := options.policyFor(method, r.URL.Path)
+
+			if !policy.allowsOrigin(origin) {
+				utils.WriteProblem(w, r, http.StatusForbidden, "Not allowed by CORS.")
+				return
+			}
+
+			// a wildcard policy never grants credentials, should Validate
+			// not have been called
+			if slices.Contains(policy.AllowedOrigins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if policy.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if !preflight {
+				if len(policy.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
 
-		next.ServeHTTP(w, r)
-	})
+			if !slices.Contains(policy.AllowedMethods, requestMethod) {
+				utils.WriteProblem(w, r, http.StatusForbidden, "Method "+requestMethod+" is not allowed by CORS.")
+				return
+			}
+			for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				header = strings.TrimSpace(header)
+				if header != "" && !slices.ContainsFunc(policy.AllowedHeaders, func(allowed string) bool {
+					return strings.EqualFold(allowed, header)
+				}) {
+					utils.WriteProblem(w, r, http.StatusForbidden, "Header "+header+" is not allowed by CORS.")
+					return
+				}
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+			if len(policy.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			}
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
 }
 
-func isOriginAllowed(origin string) bool {
-	for _, allowedOrigin := range allowedOrigins {
-		if origin == allowedOrigin {
+func (options CORSOptions) policyFor(method, path string) CORSPolicy {
+	for _, route := range options.Routes {
+		if matchesRoute(route.Pattern, method, path) {
+			return route.Policy
+		}
+	}
+	return options.Default
+}
+
+func (policy CORSPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range policy.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*."); ok && matchesSubdomain(origin, prefix, "."+suffix) {
 			return true
 		}
 	}
 	return false
 }
+
+// matchesSubdomain checks that origin is prefix, one or more host labels and
+// suffix, e.g. "https://" + "a.b" + ".example.org".
+func matchesSubdomain(origin, prefix, suffix string) bool {
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) || len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	labels := origin[len(prefix) : len(origin)-len(suffix)]
+	for _, c := range labels {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return !strings.HasPrefix(labels, ".") && !strings.HasSuffix(labels, ".")
+}
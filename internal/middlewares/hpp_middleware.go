@@ -1,79 +1,308 @@
 package middlewares
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
-	"slices"
-	"strings"
+	"net/url"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// ParamStrategy decides what happens to a parameter that is sent more than
+// once, which is how HTTP parameter pollution slips values past checks
+// that only look at one of them.
+type ParamStrategy string
+
+const (
+	ParamFirst  ParamStrategy = "first"
+	ParamLast   ParamStrategy = "last"
+	ParamReject ParamStrategy = "reject"
+	// ParamAllowArray keeps every value, for parameters that are lists such
+	// as sort-by. Repeated JSON keys are merged into an array.
+	ParamAllowArray ParamStrategy = "allow-array"
 )
 
+// HPPOptions selects what to check. Params sets the strategy of single
+// parameters, all others follow Default (ParamFirst when empty).
 type HPPOptions struct {
-	CheckQuery                  bool
-	CheckBody                   bool
-	CheckBodyOnlyForContentType string
-	Whitelist                   []string
+	CheckQuery bool
+	CheckForm  bool
+	CheckJSON  bool
+	Default    ParamStrategy
+	Params     map[string]ParamStrategy
 }
 
+func (options HPPOptions) strategy(param string) ParamStrategy {
+	if strategy, ok := options.Params[param]; ok {
+		return strategy
+	}
+	if options.Default != "" {
+		return options.Default
+	}
+	return ParamFirst
+}
+
+// Hpp resolves repeated query, form and JSON parameters by their strategy
+// and refuses requests repeating a ParamReject parameter with 400. Handlers
+// read the resolved query and form values with utils.Params.
 func Hpp(options HPPOptions) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if options.CheckBody && r.Method == http.MethodPost && isCorrectContentType(r, options.CheckBodyOnlyForContentType) {
-				// filter body params
-				filterBodyParams(r, options.Whitelist)
+			params := url.Values{}
+
+			if options.CheckQuery {
+				query, err := options.normalize(r.URL.Query())
+				if err != nil {
+					utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+					return
+				}
+				r.URL.RawQuery = query.Encode()
+				for k, v := range query {
+					params[k] = v
+				}
 			}
-			if options.CheckQuery && r.URL.Query() != nil {
-				// filter query params
-				filterQueryParams(r, options.Whitelist)
+
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			switch {
+			case options.CheckForm && mediaType == "application/x-www-form-urlencoded" && hasBody(r):
+				if err := r.ParseForm(); err != nil {
+					utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid form body.")
+					return
+				}
+				form, err := options.normalize(r.PostForm)
+				if err != nil {
+					utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+					return
+				}
+				r.PostForm = form
+				r.Form = url.Values{}
+				for k, v := range form {
+					params[k] = v
+					r.Form[k] = v
+				}
+				// form values win over query values, as in http.Request.Form
+				for k, v := range r.URL.Query() {
+					if _, ok := r.Form[k]; !ok {
+						r.Form[k] = v
+					}
+				}
+			case options.CheckJSON && mediaType == "application/json" && hasBody(r):
+				if err := options.normalizeJSONBody(r); err != nil {
+					var tooLarge *http.MaxBytesError
+					if errors.As(err, &tooLarge) {
+						utils.WriteProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes.", tooLarge.Limit))
+						return
+					}
+					utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+					return
+				}
 			}
 
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(utils.WithParams(r.Context(), params)))
 		})
 	}
 }
 
-func isCorrectContentType(r *http.Request, contentType string) bool {
-	return strings.Contains(r.Header.Get("Content-Type"), contentType)
+func hasBody(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return r.Body != nil && r.Body != http.NoBody
+	}
+	return false
 }
 
-func filterBodyParams(r *http.Request, whitelist []string) {
-	err := r.ParseForm()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+func (options HPPOptions) normalize(values url.Values) (url.Values, error) {
+	normalized := make(url.Values, len(values))
+	for k, v := range values {
+		if len(v) < 2 {
+			normalized[k] = v
+			continue
+		}
 
-	for k, v := range r.Form {
-		if len(v) > 1 {
-			r.Form.Set(k, v[0])
+		switch options.strategy(k) {
+		case ParamLast:
+			normalized[k] = v[len(v)-1:]
+		case ParamReject:
+			return nil, fmt.Errorf("Parameter %s must not be repeated.", k)
+		case ParamAllowArray:
+			normalized[k] = v
+		default:
+			normalized[k] = v[:1]
 		}
+	}
+	return normalized, nil
+}
 
-		if isWhitelisted(k, whitelist) {
-			delete(r.Form, k)
+// maxJSONBodySize bounds the JSON bodies buffered here, for chains without
+// the decompression middleware in front, which caps them at its MaxSize.
+const maxJSONBodySize = 32 << 20
 
-		}
+// normalizeJSONBody resolves repeated object keys, which encoding/json would
+// silently collapse to the last one. The body is only rewritten when it has
+// any; malformed JSON is left for the handler to report.
+func (options HPPOptions) normalizeJSONBody(r *http.Request) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBodySize+1))
+	r.Body.Close()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	} else if err != nil {
+		return errors.New("Error reading request body.")
+	}
+	if len(body) > maxJSONBodySize {
+		return &http.MaxBytesError{Limit: maxJSONBodySize}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
 
+	var repeated bool
+	normalized, err := options.normalizeJSON(dec, "$", &repeated)
+	var repeatedErr *repeatedJSONKey
+	if errors.As(err, &repeatedErr) {
+		return err
+	} else if err != nil || !repeated {
+		return nil
 	}
 
+	r.Body = io.NopCloser(bytes.NewReader(normalized))
+	r.ContentLength = int64(len(normalized))
+	r.Header.Set("Content-Length", fmt.Sprint(len(normalized)))
+	return nil
 }
 
-func isWhitelisted(param string, whitelist []string) bool {
-	return slices.Contains(whitelist, param)
+type repeatedJSONKey struct{ path string }
+
+func (e *repeatedJSONKey) Error() string {
+	return fmt.Sprintf("Key %s must not be repeated.", e.path)
 }
 
-func filterQueryParams(r *http.Request, whitelist []string) {
-	query := r.URL.Query()
+// jsonStrategy is strategy for an object key, matched by its folded name.
+func (options HPPOptions) jsonStrategy(folded string) ParamStrategy {
+	for param, strategy := range options.Params {
+		if foldJSONKey(param) == folded {
+			return strategy
+		}
+	}
+	return options.strategy(folded)
+}
 
-	for k, v := range query {
-		if len(v) > 1 {
-			query.Set(k, v[0])
+// foldJSONKey folds key as encoding/json does when it matches object keys
+// to struct fields, so "class", "Class" and "CLASS" are the same key.
+func foldJSONKey(key string) string {
+	folded := make([]rune, 0, len(key))
+	for _, r := range key {
+		if r < utf8.RuneSelf {
+			folded = append(folded, unicode.ToUpper(r))
+			continue
+		}
+		// the smallest rune of its fold set
+		for {
+			next := unicode.SimpleFold(r)
+			if next <= r {
+				r = next
+				break
+			}
+			r = next
 		}
+		folded = append(folded, r)
+	}
+	return string(folded)
+}
 
-		if isWhitelisted(k, whitelist) {
-			query.Del(k)
+// normalizeJSON re-encodes the next value of dec with repeated keys
+// resolved, reporting through repeated whether there were any. Keys repeat
+// regardless of case, encoding/json matches them to fields case-insensitively;
+// the first spelling is kept.
+func (options HPPOptions) normalizeJSON(dec *json.Decoder, path string, repeated *bool) ([]byte, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
 
+	switch tok {
+	case json.Delim('{'):
+		var keys []string
+		names := map[string]string{}
+		values := map[string][][]byte{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := keyTok.(string)
+			value, err := options.normalizeJSON(dec, path+"."+key, repeated)
+			if err != nil {
+				return nil, err
+			}
+			folded := foldJSONKey(key)
+			if _, seen := values[folded]; !seen {
+				keys = append(keys, folded)
+				names[folded] = key
+			}
+			values[folded] = append(values[folded], value)
 		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for i, folded := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key := names[folded]
+			name, _ := json.Marshal(key)
+			buf.Write(name)
+			buf.WriteByte(':')
+
+			v := values[folded]
+			if len(v) > 1 {
+				*repeated = true
+			}
+			strategy := options.jsonStrategy(folded)
+			switch {
+			case len(v) == 1:
+				buf.Write(v[0])
+			case strategy == ParamLast:
+				buf.Write(v[len(v)-1])
+			case strategy == ParamReject:
+				return nil, &repeatedJSONKey{path: path + "." + key}
+			case strategy == ParamAllowArray:
+				buf.WriteByte('[')
+				buf.Write(bytes.Join(v, []byte(",")))
+				buf.WriteByte(']')
+			default:
+				buf.Write(v[0])
+			}
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
 
+	case json.Delim('['):
+		var items [][]byte
+		for i := 0; dec.More(); i++ {
+			item, err := options.normalizeJSON(dec, fmt.Sprintf("%s[%d]", path, i), repeated)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return append(append([]byte("["), bytes.Join(items, []byte(","))...), ']'), nil
 	}
 
-	r.URL.RawQuery = query.Encode()
+	// strings, json.Number, booleans and null
+	return json.Marshal(tok)
 }
@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveJSON runs body through Hpp and returns the status and the body the
+// handler received.
+func serveJSON(t *testing.T, options HPPOptions, body string) (int, string) {
+	t.Helper()
+
+	var received string
+	handler := Hpp(options)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/teachers/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code, received
+}
+
+func TestHppJSONCaseVariantKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		options HPPOptions
+		body    string
+		status  int
+		class   string
+	}{
+		{"reject", HPPOptions{CheckJSON: true, Default: ParamReject}, `{"class":"A","Class":"B"}`, http.StatusBadRequest, ""},
+		{"reject folded rune", HPPOptions{CheckJSON: true, Default: ParamReject}, `{"class":"A","claſſ":"B"}`, http.StatusBadRequest, ""},
+		{"first", HPPOptions{CheckJSON: true, Default: ParamFirst}, `{"class":"A","CLASS":"B"}`, http.StatusOK, "A"},
+		{"last", HPPOptions{CheckJSON: true, Default: ParamLast}, `{"class":"A","Class":"B"}`, http.StatusOK, "B"},
+		{"param strategy", HPPOptions{CheckJSON: true, Default: ParamFirst, Params: map[string]ParamStrategy{"class": ParamReject}}, `{"Class":"A","class":"B"}`, http.StatusBadRequest, ""},
+		{"distinct keys", HPPOptions{CheckJSON: true, Default: ParamReject}, `{"class":"A","subject":"B"}`, http.StatusOK, "A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, received := serveJSON(t, tt.options, tt.body)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			// decoded the way handlers do
+			var teacher struct {
+				Class string `json:"class"`
+			}
+			if err := json.Unmarshal([]byte(received), &teacher); err != nil {
+				t.Fatalf("handler received %q: %v", received, err)
+			}
+			if teacher.Class != tt.class {
+				t.Fatalf("handler decoded class %q from %q, want %q", teacher.Class, received, tt.class)
+			}
+		})
+	}
+}
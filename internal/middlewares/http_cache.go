@@ -0,0 +1,214 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// maxCachedValidators bounds the entries of a ResponseCache.
+const maxCachedValidators = 10000
+
+type cachedValidator struct {
+	etag         string
+	lastModified time.Time
+	storedAt     time.Time
+}
+
+// ResponseCache remembers the ETag and Last-Modified of GET responses per
+// tenant and URL, so Last-Modified only moves when the body changed.
+// Conditional requests are still answered from the body the handler
+// produces, writes handled by other replicas are never seen here.
+// Successful mutations drop what they may have changed, and validators
+// expire after ttl.
+type ResponseCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	validators map[string]cachedValidator
+	// generation counts invalidations, a response computed across one is
+	// not remembered
+	generation uint64
+}
+
+func NewResponseCache(ttl time.Duration) *ResponseCache {
+	return &ResponseCache{ttl: ttl, validators: map[string]cachedValidator{}}
+}
+
+func cacheKey(r *http.Request) string {
+	return utils.TenantSlug(r.Context()) + " " + r.URL.Path + "?" + r.URL.RawQuery
+}
+
+// Cached gives the GET responses of a route a strong ETag over their body,
+// a Last-Modified and cacheControl, and honours If-None-Match and
+// If-Modified-Since. The handler always runs, a 304 saves the transfer of
+// the body, never the query. It goes after the permission check of the
+// route, a 304 must not tell anything to clients that may not read the
+// resource.
+func (c *ResponseCache) Cached(cacheControl string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := cacheKey(r)
+			generation := c.lookup(key)
+
+			rec := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			if rec.status != http.StatusOK {
+				w.WriteHeader(rec.status)
+				w.Write(rec.body.Bytes())
+				return
+			}
+
+			sum := sha256.Sum256(rec.body.Bytes())
+			validator := c.store(key, generation, `"`+base64.RawURLEncoding.EncodeToString(sum[:18])+`"`)
+			writeValidators(w, validator, cacheControl)
+			if notModified(r, validator) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(rec.body.Bytes())
+		})
+	}
+}
+
+// lookup drops the validator of key once expired and returns the generation
+// to pass to store.
+func (c *ResponseCache) lookup(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if validator, ok := c.validators[key]; ok && time.Since(validator.storedAt) > c.ttl {
+		delete(c.validators, key)
+	}
+	return c.generation
+}
+
+// store remembers etag unless an invalidation happened since generation.
+// Last-Modified moves only when the ETag changed.
+func (c *ResponseCache) store(key string, generation uint64, etag string) cachedValidator {
+	now := time.Now()
+	validator := cachedValidator{etag: etag, lastModified: now.Truncate(time.Second), storedAt: now}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if previous, ok := c.validators[key]; ok && previous.etag == etag {
+		validator.lastModified = previous.lastModified
+	}
+	if generation != c.generation {
+		return validator
+	}
+	if _, ok := c.validators[key]; !ok && len(c.validators) >= maxCachedValidators {
+		c.dropExpired(now)
+		if len(c.validators) >= maxCachedValidators {
+			return validator
+		}
+	}
+	c.validators[key] = validator
+	return validator
+}
+
+func (c *ResponseCache) dropExpired(now time.Time) {
+	for key, validator := range c.validators {
+		if now.Sub(validator.storedAt) > c.ttl {
+			delete(c.validators, key)
+		}
+	}
+}
+
+// Invalidate drops the validators a successful POST, PUT, PATCH or DELETE
+// may have changed: those of the resource, of everything below it and of
+// the collection it belongs to. A change of /teachers/7 thus reaches
+// /teachers/7 and /teachers/, one of /teachers/ every teacher.
+func (c *ResponseCache) Invalidate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &ResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.status >= 300 {
+			return
+		}
+
+		resource := strings.TrimSuffix(r.URL.Path, "/")
+		collection := strings.TrimSuffix(path.Dir(resource), "/") + "/"
+		tenant := utils.TenantSlug(r.Context()) + " "
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.generation++
+		for key := range c.validators {
+			keyTenant, rest, _ := strings.Cut(key, " ")
+			if keyTenant+" " != tenant {
+				continue
+			}
+			keyPath, _, _ := strings.Cut(rest, "?")
+			if keyPath == resource || keyPath == collection || strings.HasPrefix(keyPath, resource+"/") {
+				delete(c.validators, key)
+			}
+		}
+	})
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is
+// none, as RFC 9110 section 13.2.2 orders them. If-None-Match compares
+// weakly, the compression middleware turns the ETag weak.
+func notModified(r *http.Request, validator cachedValidator) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == validator.etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !validator.lastModified.After(since)
+}
+
+func writeValidators(w http.ResponseWriter, validator cachedValidator, cacheControl string) {
+	w.Header().Set("ETag", validator.etag)
+	w.Header().Set("Last-Modified", validator.lastModified.UTC().Format(http.TimeFormat))
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+}
+
+// bufferedResponse holds the body back until its ETag is known.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status = status
+		b.wroteHeader = true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
@@ -0,0 +1,220 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// IPRule restricts the routes matching Pattern, a path or "METHOD path"
+// like RateLimitRule patterns. A client in Deny is refused, and when Allow
+// lists networks the client has to be in one of them. Networks are IPv4 or
+// IPv6 CIDRs or single addresses.
+type IPRule struct {
+	Pattern string   `json:"pattern"`
+	Allow   []string `json:"allow,omitempty"`
+	Deny    []string `json:"deny,omitempty"`
+
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func (rule *IPRule) permits(addr netip.Addr) bool {
+	if utils.PrefixesContain(rule.deny, addr) {
+		return false
+	}
+	return len(rule.allow) == 0 || utils.PrefixesContain(rule.allow, addr)
+}
+
+// IPFilter refuses requests from networks the IP rules do not permit. Every
+// rule matching a request applies, so a catch-all "/" rule can deny
+// networks everywhere while narrower rules lock down admin routes:
+//
+//	[
+//	  {"pattern": "/", "deny": ["203.0.113.0/24"]},
+//	  {"pattern": "/audit-log", "allow": ["10.20.0.0/16", "fd00:20::/32"]},
+//	  {"pattern": "/tenants", "allow": ["10.20.0.0/16"]}
+//	]
+type IPFilter struct {
+	path  string
+	rules atomic.Pointer[[]IPRule]
+
+	modTime time.Time
+}
+
+// NewIPFilter loads the rules from the JSON list in path.
+func NewIPFilter(path string) (*IPFilter, error) {
+	f := &IPFilter{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload replaces the rules with the current contents of the file. The old
+// rules stay in place when the file is invalid.
+func (f *IPFilter) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("reading IP rules: %w", err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("reading IP rules: %w", err)
+	}
+
+	var rules []IPRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Pattern == "" {
+			return fmt.Errorf("IP rule %d: pattern is required", i)
+		}
+		for _, network := range rule.Allow {
+			prefixes, err := utils.ParseCIDRs(network)
+			if err != nil {
+				return fmt.Errorf("IP rule %d: %w", i, err)
+			}
+			rule.allow = append(rule.allow, prefixes...)
+		}
+		for _, network := range rule.Deny {
+			prefixes, err := utils.ParseCIDRs(network)
+			if err != nil {
+				return fmt.Errorf("IP rule %d: %w", i, err)
+			}
+			rule.deny = append(rule.deny, prefixes...)
+		}
+	}
+
+	f.rules.Store(&rules)
+	f.modTime = info.ModTime()
+	return nil
+}
+
+// Watch reloads the rules on SIGHUP and whenever the file changes, checking
+// every interval.
+func (f *IPFilter) Watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+			case <-ticker.C:
+				info, err := os.Stat(f.path)
+				if err != nil || info.ModTime().Equal(f.modTime) {
+					continue
+				}
+				// an invalid file is reported once, not on every tick
+				f.modTime = info.ModTime()
+			}
+
+			if err := f.Reload(); err != nil {
+				log.Println("keeping previous IP rules:", err)
+				continue
+			}
+			log.Println("reloaded IP rules from", f.path)
+		}
+	}()
+}
+
+// Middleware needs the client IP middleware in front of it to see clients
+// behind proxies, and the tenant middleware for the audit trail.
+func (f *IPFilter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := utils.ClientIP(r)
+		addr, err := netip.ParseAddr(ip)
+
+		for _, rule := range *f.rules.Load() {
+			if !matchesRoute(rule.Pattern, r.Method, r.URL.Path) {
+				continue
+			}
+			if err != nil || !rule.permits(addr) {
+				auditIPDenied(r, ip, rule.Pattern)
+				utils.WriteProblem(w, r, http.StatusForbidden, "Your network may not access this resource.")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ipDeniedAuditWindow is how often a client refused by the same rule is
+// audited. Blocked traffic is cheap to send and must not turn into as many
+// database writes.
+const ipDeniedAuditWindow = time.Minute
+
+type deniedClient struct {
+	since      time.Time
+	suppressed int
+}
+
+var (
+	ipDeniedMu      sync.Mutex
+	ipDeniedClients = map[string]*deniedClient{}
+)
+
+// auditIPDenied writes one audit event per IP and rule and minute, counting
+// the refusals in between. Events go to the default tenant: the tenant of
+// the request is whatever the refused client asked for.
+func auditIPDenied(r *http.Request, ip, pattern string) {
+	now := time.Now()
+	key := ip + " " + pattern
+
+	ipDeniedMu.Lock()
+	client, ok := ipDeniedClients[key]
+	if ok && now.Sub(client.since) < ipDeniedAuditWindow {
+		client.suppressed++
+		ipDeniedMu.Unlock()
+		return
+	}
+	suppressed := 0
+	if ok {
+		suppressed = client.suppressed
+	}
+	if len(ipDeniedClients) > 10000 {
+		for k, c := range ipDeniedClients {
+			if now.Sub(c.since) >= ipDeniedAuditWindow {
+				delete(ipDeniedClients, k)
+			}
+		}
+	}
+	ipDeniedClients[key] = &deniedClient{since: now}
+	ipDeniedMu.Unlock()
+
+	detail := fmt.Sprintf("%s %s refused by rule %q", r.Method, r.URL.Path, pattern)
+	if slug := requestTenantSlug(r); slug != "" {
+		detail += fmt.Sprintf(" for tenant %q", slug)
+	}
+	if suppressed > 0 {
+		detail += fmt.Sprintf(", %d more refusals since the last event", suppressed)
+	}
+	log.Printf("audit: ip_denied %s from %s", detail, ip)
+
+	err := sqlconnect.AddAuditEventDB(context.Background(), models.AuditEvent{
+		Event:  "ip_denied",
+		IP:     ip,
+		Detail: detail,
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
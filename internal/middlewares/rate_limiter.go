@@ -2,52 +2,140 @@ package middlewares
 
 import (
 	"fmt"
+	"log"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/ratelimit"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
 )
 
-type rateLimiter struct {
-	mu        sync.Mutex
-	visitors  map[string]int
-	limit     int
-	resetTime time.Duration
+// RateLimitPolicy is a token bucket holding Limit requests that refills
+// completely over Window, so bursts of up to Limit requests are allowed and
+// the sustained rate is Limit per Window.
+type RateLimitPolicy struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitRule applies Policy to the routes matching Pattern, which is a
+// path or "METHOD path" like the keys of publicRoutes. A path ending in a
+// slash matches everything below it. Every rule has its own buckets.
+type RateLimitRule struct {
+	Pattern string
+	Policy  RateLimitPolicy
 }
 
-func NewRateLimiter(limit int, resetTime time.Duration) *rateLimiter {
-	rl := &rateLimiter{
-		visitors:  make(map[string]int),
-		limit:     limit,
-		resetTime: resetTime,
+func (rule RateLimitRule) matches(r *http.Request) bool {
+	return matchesRoute(rule.Pattern, r.Method, r.URL.Path)
+}
+
+// matchesRoute matches a path or "METHOD path" pattern, a path ending in a
+// slash matching everything below it.
+func matchesRoute(pattern, method, path string) bool {
+	if patternMethod, patternPath, ok := strings.Cut(pattern, " "); ok {
+		if patternMethod != method {
+			return false
+		}
+		pattern = patternPath
 	}
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern)
+	}
+	return path == pattern
+}
+
+// RateLimiter limits requests per client. Rules are checked in order,
+// requests matching none fall under the default policy. The buckets live in
+// store, which replicas can share.
+type RateLimiter struct {
+	store  ratelimit.Store
+	policy RateLimitPolicy
+	rules  []RateLimitRule
+
+	// layer keeps the buckets of limiters sharing a store apart
+	layer string
+	key   func(r *http.Request) string
+}
 
-	go rl.resetVisitorCount()
-	return rl
+// NewRateLimiter limits API keys and signed-in users by their identity,
+// everyone else by client IP. Its middleware has to run inside Authenticate
+// to tell clients apart by their credentials.
+func NewRateLimiter(store ratelimit.Store, policy RateLimitPolicy, rules ...RateLimitRule) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		policy: policy,
+		rules:  rules,
+		layer:  "client",
+		key:    rateLimitKey,
+	}
 }
 
-func (rl *rateLimiter) resetVisitorCount() {
-	for {
-		time.Sleep(rl.resetTime)
-		rl.mu.Lock()
-		rl.visitors = make(map[string]int)
-		rl.mu.Unlock()
+// NewIPRateLimiter limits every request by client IP alone. Its middleware
+// goes outside Authenticate, so requests with bad credentials and the
+// lookups they cause are limited too.
+func NewIPRateLimiter(store ratelimit.Store, policy RateLimitPolicy, rules ...RateLimitRule) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		policy: policy,
+		rules:  rules,
+		layer:  "ip",
+		key: func(r *http.Request) string {
+			return "ip:" + utils.ClientIP(r)
+		},
 	}
 }
 
-func (rl *rateLimiter) Middleware(next http.Handler) http.Handler {
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
+		name, policy := "default", rl.policy
+		for _, rule := range rl.rules {
+			if rule.matches(r) {
+				name, policy = rule.Pattern, rule.Policy
+				break
+			}
+		}
+
+		result, err := rl.store.Take(r.Context(), rl.layer+"|"+name+"|"+rl.key(r), policy.Limit, policy.Window)
+		if err != nil {
+			// an unreachable store must not take the API down with it
+			log.Println("rate limiter:", err)
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		visitorIp := r.RemoteAddr
-		rl.visitors[visitorIp]++
-		fmt.Printf("Visitor count from %v is %v", visitorIp, rl.visitors[visitorIp])
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window.Seconds())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
 
-		if rl.visitors[visitorIp] > rl.limit {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+			utils.WriteProblem(w, r, http.StatusTooManyRequests, "Too many requests, slow down.")
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// rateLimitKey identifies the client of r. Account IDs are only unique
+// within a tenant.
+func rateLimitKey(r *http.Request) string {
+	if p := utils.PrincipalFrom(r); p != nil {
+		switch {
+		case p.APIKeyID != 0:
+			return fmt.Sprintf("key:%s/%d", p.Tenant, p.APIKeyID)
+		case p.AccountID != 0:
+			return fmt.Sprintf("user:%s/%d", p.Tenant, p.AccountID)
+		}
+	}
+	return "ip:" + utils.ClientIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
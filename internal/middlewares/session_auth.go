@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written. The idle
+// timeout is therefore only accurate to this interval.
+const sessionTouchInterval = time.Minute
+
+// sessionPrincipal resolves the session cookie. It returns nil and no error
+// when the request has none.
+func sessionPrincipal(r *http.Request) (*utils.Principal, error) {
+	cookie, err := r.Cookie(utils.SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+
+	session, err := sqlconnect.GetSessionDB(r.Context(), utils.HashToken(cookie.Value))
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, invalidCredentials("Session has expired.")
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.Sub(session.LastSeenAt) > utils.SessionIdleTimeout() {
+		if err := sqlconnect.RevokeSessionDB(r.Context(), session.ID); err != nil {
+			log.Println(err)
+		}
+		return nil, invalidCredentials("Session has expired.")
+	}
+
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := sqlconnect.TouchSessionDB(r.Context(), session.ID); err != nil {
+			log.Println(err)
+		}
+	}
+
+	account, err := sqlconnect.GetAccountByIdDB(r.Context(), session.AccountID)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, invalidCredentials("Session has expired.")
+	} else if err != nil {
+		return nil, err
+	}
+
+	principal, err := sqlconnect.AccountPrincipalDB(r.Context(), account)
+	if err != nil {
+		return nil, err
+	}
+	principal.SessionID = session.ID
+	principal.CSRFToken = session.CSRFToken
+	return &principal, nil
+}
+
+// validCSRF checks the synchronizer token of cookie authenticated requests.
+// Safe methods and other credentials need none, browsers do not attach
+// Authorization headers on their own.
+func validCSRF(r *http.Request, p *utils.Principal) bool {
+	if p.SessionID == 0 {
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	token := r.Header.Get(utils.CSRFHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(p.CSRFToken)) == 1
+}
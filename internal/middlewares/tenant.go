@@ -0,0 +1,104 @@
+package middlewares
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository/sqlconnect"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// TenantHeader names the tenant of requests that do not come in through a
+// tenant subdomain.
+const TenantHeader = "X-Tenant-ID"
+
+// tenantCacheTTL bounds how long a tenant lookup is reused.
+const tenantCacheTTL = time.Minute
+
+type cachedTenant struct {
+	tenant    *utils.Tenant
+	fetchedAt time.Time
+}
+
+var (
+	tenantCacheMu sync.Mutex
+	tenantCache   = map[string]cachedTenant{}
+)
+
+// Tenant resolves the school a request is for and puts it on the request
+// context, where the repository picks the tenant database from. The tenant
+// is named by, in this order, the subdomain of TENANT_BASE_DOMAIN
+// (<slug>.schools.example.org), the X-Tenant-ID header or the tenant claim
+// of the bearer token. Requests naming no tenant are served by the default
+// tenant in the control database.
+func Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug := requestTenantSlug(r)
+		if slug == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tenant, err := lookupTenant(r, slug)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.WriteProblem(w, r, http.StatusNotFound, "Unknown tenant "+slug+".")
+			return
+		} else if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(utils.WithTenant(r.Context(), tenant)))
+	})
+}
+
+func requestTenantSlug(r *http.Request) string {
+	if base := strings.ToLower(os.Getenv("TENANT_BASE_DOMAIN")); base != "" {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if sub, ok := strings.CutSuffix(host, "."+base); ok && sub != "" && !strings.Contains(sub, ".") {
+			return sub
+		}
+	}
+
+	if slug := strings.TrimSpace(r.Header.Get(TenantHeader)); slug != "" {
+		return strings.ToLower(slug)
+	}
+
+	// the token is verified again, and checked for revocation, by
+	// Authenticate once its tenant database is known
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if strings.EqualFold(scheme, "Bearer") && token != "" {
+		if claims, err := utils.ParseAccessToken(token); err == nil {
+			return claims.Tenant
+		}
+	}
+	return ""
+}
+
+func lookupTenant(r *http.Request, slug string) (*utils.Tenant, error) {
+	tenantCacheMu.Lock()
+	cached, ok := tenantCache[slug]
+	tenantCacheMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < tenantCacheTTL {
+		return cached.tenant, nil
+	}
+
+	row, err := sqlconnect.GetTenantBySlugDB(r.Context(), slug)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant := &utils.Tenant{Slug: row.Slug, Database: row.DBName}
+	tenantCacheMu.Lock()
+	tenantCache[slug] = cachedTenant{tenant: tenant, fetchedAt: time.Now()}
+	tenantCacheMu.Unlock()
+	return tenant, nil
+}
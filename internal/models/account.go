@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type Account struct {
+	ID              int        `json:"id,omitempty"`
+	Username        string     `json:"username,omitempty" validate:"required,min=3,max=100"`
+	Email           string     `json:"email,omitempty" validate:"required,max=255,email"`
+	Password        string     `json:"password,omitempty" validate:"required,min=12,max=128"`
+	PasswordHash    string     `json:"-"`
+	Role            string     `json:"role,omitempty" validate:"oneof=exec|teacher|student"`
+	TeacherID       *int       `json:"teacher_id,omitempty"`
+	StudentID       *int       `json:"student_id,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	TOTPEnabledAt   *time.Time `json:"totp_enabled_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at,omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// ApiKeyPrefix starts every API key, the 8 hex characters after it identify
+// the key in the database: sk_<prefix>_<secret>.
+const ApiKeyPrefix = "sk_"
+
+// ApiKey authenticates machine clients. Key holds the secret and is only
+// set in the response that creates the key.
+type ApiKey struct {
+	ID         int        `json:"id,omitempty"`
+	AccountID  int        `json:"account_id,omitempty"`
+	Name       string     `json:"name,omitempty" validate:"required,max=100"`
+	Prefix     string     `json:"prefix,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at,omitempty"`
+}
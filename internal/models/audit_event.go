@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// AuditEvent records a security relevant action. AccountID is the account
+// the event is about and ActorAccountID the caller who caused it, if any.
+type AuditEvent struct {
+	ID             int       `json:"id,omitempty"`
+	Event          string    `json:"event"`
+	AccountID      *int      `json:"account_id,omitempty"`
+	ActorAccountID *int      `json:"actor_account_id,omitempty"`
+	IP             string    `json:"ip,omitempty"`
+	Detail         string    `json:"detail,omitempty"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+}
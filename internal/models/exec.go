@@ -1,8 +1,8 @@
 package models
 
 type Exec struct {
-	ID        int    `json: "id,omitempty"`
-	FirstName string `json:"first_name,omitempty"`
-	LastName  string `json:"last_name,omitempty"`
-	Email     string `json:"email,omitempty"`
+	ID        int    `json:"id,omitempty"`
+	FirstName string `json:"first_name,omitempty" validate:"required,max=100,pattern=name"`
+	LastName  string `json:"last_name,omitempty" validate:"required,max=100,pattern=name"`
+	Email     string `json:"email,omitempty" validate:"required,max=255,email"`
 }
@@ -0,0 +1,6 @@
+package models
+
+type SecurityPolicy struct {
+	Role       string `json:"role" validate:"required,oneof=exec|teacher|student"`
+	Require2FA bool   `json:"require_2fa"`
+}
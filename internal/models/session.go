@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Session is a cookie based browser login. CSRFToken has to accompany every
+// unsafe request made with the session cookie.
+type Session struct {
+	ID         int       `json:"-"`
+	AccountID  int       `json:"account_id"`
+	CSRFToken  string    `json:"csrf_token"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
@@ -2,9 +2,9 @@ package models
 
 type Teacher struct {
 	ID        int    `json:"id,omitempty"`
-	FirstName string `json:"first_name,omitempty"`
-	LastName  string `json:"last_name,omitempty"`
-	Email     string `json:"email,omitempty"`
-	Class     string `json:"class,omitempty"`
-	Subject   string `json:"subject,omitempty"`
+	FirstName string `json:"first_name,omitempty" validate:"required,max=100,pattern=name"`
+	LastName  string `json:"last_name,omitempty" validate:"required,max=100,pattern=name"`
+	Email     string `json:"email,omitempty" validate:"required,max=255,email"`
+	Class     string `json:"class,omitempty" validate:"required,pattern=class"`
+	Subject   string `json:"subject,omitempty" validate:"required,oneof=Math|English|Science|Physics|Chemistry|Biology|History|Geography|Art|Music|Physical Education|Computer Science"`
 }
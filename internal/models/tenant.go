@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Tenant is a school hosted on this instance. Requests name it by its Slug,
+// its records live in their own database DBName.
+type Tenant struct {
+	ID        int       `json:"id,omitempty"`
+	Slug      string    `json:"slug,omitempty" validate:"required,min=2,max=40,pattern=slug"`
+	Name      string    `json:"name,omitempty" validate:"required,max=255"`
+	DBName    string    `json:"db_name,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
@@ -0,0 +1,15 @@
+package models
+
+// TimetableSlot is a weekly recurring lesson. Weekday follows ISO 8601
+// (1 = Monday ... 7 = Sunday) and times are "HH:MM" in school local time.
+type TimetableSlot struct {
+	ID        int    `json:"id,omitempty"`
+	TeacherID int    `json:"teacher_id,omitempty"`
+	Class     string `json:"class,omitempty"`
+	Course    string `json:"course,omitempty"`
+	Weekday   int    `json:"weekday,omitempty"`
+	Period    int    `json:"period,omitempty"`
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	Room      string `json:"room,omitempty"`
+}
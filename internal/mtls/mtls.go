@@ -0,0 +1,189 @@
+// Package mtls configures TLS client certificate authentication and maps
+// verified client certificates to API principals.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// clientAuthModes maps TLS_CLIENT_AUTH to the tls package. Only certificates
+// that were verified against TLS_CLIENT_CA_FILE ("verify" and
+// "require_verify") can authenticate a request, "request" and "require"
+// merely ask for one.
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"":               tls.NoClientCert,
+	"none":           tls.NoClientCert,
+	"request":        tls.RequestClientCert,
+	"require":        tls.RequireAnyClientCert,
+	"verify":         tls.VerifyClientCertIfGiven,
+	"require_verify": tls.RequireAndVerifyClientCert,
+}
+
+// ConfigureFromEnv sets the client certificate policy of cfg from
+// TLS_CLIENT_AUTH and the CA bundle in TLS_CLIENT_CA_FILE.
+func ConfigureFromEnv(cfg *tls.Config) error {
+	mode := strings.ToLower(os.Getenv("TLS_CLIENT_AUTH"))
+	clientAuth, ok := clientAuthModes[mode]
+	if !ok {
+		return fmt.Errorf("unknown TLS_CLIENT_AUTH %q", mode)
+	}
+	cfg.ClientAuth = clientAuth
+
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if caFile == "" {
+		if clientAuth >= tls.VerifyClientCertIfGiven {
+			return fmt.Errorf("TLS_CLIENT_AUTH %q needs TLS_CLIENT_CA_FILE", mode)
+		}
+		return nil
+	}
+
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		return err
+	}
+	cfg.ClientCAs = pool
+	return nil
+}
+
+// LoadCertPool reads a PEM bundle of one or more CA certificates.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// Identity grants a client certificate access. Match selects certificates by
+// one of "uri:<SAN URI>", "dns:<SAN DNS name>", "email:<SAN email>",
+// "cn:<subject common name>" or "subject:<full subject DN>".
+//
+// Role is either exec or client_cert, the latter limited to Scopes.
+// AccountID names the account the client acts for, e.g. as owner of the API
+// keys it creates.
+type Identity struct {
+	Match     string   `json:"match"`
+	Username  string   `json:"username"`
+	Role      string   `json:"role"`
+	Scopes    []string `json:"scopes"`
+	AccountID int      `json:"account_id"`
+}
+
+// Identities resolves certificates to principals.
+type Identities struct {
+	byMatch map[string]Identity
+}
+
+// LoadIdentities reads a JSON array of Identity from path.
+func LoadIdentities(path string) (*Identities, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading client identities: %w", err)
+	}
+
+	var list []Identity
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return NewIdentities(list)
+}
+
+func NewIdentities(list []Identity) (*Identities, error) {
+	ids := &Identities{byMatch: make(map[string]Identity, len(list))}
+	for i, id := range list {
+		kind, value, _ := strings.Cut(id.Match, ":")
+		if value == "" || !slices.Contains([]string{"uri", "dns", "email", "cn", "subject"}, kind) {
+			return nil, fmt.Errorf("identity %d: invalid match %q", i, id.Match)
+		}
+
+		switch id.Role {
+		case authz.RoleExec:
+			if id.AccountID == 0 {
+				return nil, fmt.Errorf("identity %d: exec identities need the account_id they act for", i)
+			}
+		case authz.RoleClientCert:
+			if len(id.Scopes) == 0 {
+				return nil, fmt.Errorf("identity %d: client_cert identities need scopes", i)
+			}
+			for _, scope := range id.Scopes {
+				if !slices.Contains(authz.Permissions, scope) {
+					return nil, fmt.Errorf("identity %d: unknown scope %q", i, scope)
+				}
+				// same limits as API keys, use role exec for full access
+				if scope == authz.ApiKeysManage || scope == authz.SecurityManage || scope == authz.TenantsManage {
+					return nil, fmt.Errorf("identity %d: scope %q cannot be granted to client certificates", i, scope)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("identity %d: role must be %s or %s", i, authz.RoleExec, authz.RoleClientCert)
+		}
+
+		if id.Username == "" {
+			id.Username = id.Match
+		}
+		ids.byMatch[normalizeMatch(kind, value)] = id
+	}
+	return ids, nil
+}
+
+// Principal returns the principal of the first identity matching cert,
+// trying SANs before the subject, or nil when none does. cert must have
+// been verified by the TLS handshake.
+func (ids *Identities) Principal(cert *x509.Certificate) *utils.Principal {
+	if ids == nil || cert == nil {
+		return nil
+	}
+
+	var candidates []string
+	for _, u := range cert.URIs {
+		candidates = append(candidates, normalizeMatch("uri", u.String()))
+	}
+	for _, name := range cert.DNSNames {
+		candidates = append(candidates, normalizeMatch("dns", name))
+	}
+	for _, email := range cert.EmailAddresses {
+		candidates = append(candidates, normalizeMatch("email", email))
+	}
+	if cert.Subject.CommonName != "" {
+		candidates = append(candidates, normalizeMatch("cn", cert.Subject.CommonName))
+	}
+	candidates = append(candidates, normalizeMatch("subject", cert.Subject.String()))
+
+	for _, candidate := range candidates {
+		id, ok := ids.byMatch[candidate]
+		if !ok {
+			continue
+		}
+		return &utils.Principal{
+			AccountID: id.AccountID,
+			Username:  "cert:" + id.Username,
+			Role:      id.Role,
+			Scopes:    id.Scopes,
+		}
+	}
+	return nil
+}
+
+// normalizeMatch lower-cases the parts of a match that compare case
+// insensitively. URIs and subjects compare exactly.
+func normalizeMatch(kind, value string) string {
+	switch kind {
+	case "dns", "email", "cn":
+		value = strings.ToLower(value)
+	}
+	return kind + ":" + value
+}
@@ -0,0 +1,193 @@
+// Package proxyproto accepts connections from load balancers that announce
+// the original client with the PROXY protocol, versions 1 (text) and 2
+// (binary), see https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+//
+// The header is read lazily on the connection's own goroutine, so a slow
+// peer cannot stall Accept.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	v1MaxLength   = 107
+	headerTimeout = 5 * time.Second
+)
+
+// Listener reads the PROXY header of connections from Trusted peers, every
+// other peer keeps its own address. A trusted peer that sends no header is
+// taken as connecting directly.
+type Listener struct {
+	net.Listener
+	Trusted []netip.Prefix
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	peer, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !utils.PrefixesContain(l.Trusted, peer.AddrPort().Addr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Conn is a connection whose RemoteAddr is the client named by its PROXY
+// header.
+type Conn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	c.remoteAddr, c.err = readHeader(c.r)
+	if c.err != nil {
+		c.err = fmt.Errorf("proxy protocol: %w", c.err)
+		c.Conn.Close()
+	}
+}
+
+// readHeader consumes a v1 or v2 header if the stream starts with one. It
+// returns nil when there is none or it carries no address (UNKNOWN, LOCAL).
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		// too short for a header, let the application see what there is
+		return nil, nil
+	}
+	if bytes.Equal(start, v1Prefix) {
+		return readV1(r)
+	}
+
+	start, err = r.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(start, v2Signature) {
+		return readV2(r)
+	}
+	return nil, nil
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <src port> <dst port>\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header too long")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header %q", line)
+	}
+
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil || addr.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readV2 parses the binary header: signature, version and command,
+// address family, length and the addresses, followed by TLVs that are
+// skipped.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", header[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	command, family := header[12]&0x0f, header[13]
+	switch command {
+	case 0x0:
+		// LOCAL: health checks of the proxy itself
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported v2 command %d", command)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short v2 IPv4 address block")
+		}
+		addr := netip.AddrFrom4([4]byte(body[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(body[8:10]))), nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short v2 IPv6 address block")
+		}
+		addr := netip.AddrFrom16([16]byte(body[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(body[32:34]))), nil
+	}
+	// UDP and unix sockets carry no address the API can use
+	return nil, nil
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// evictInterval is how often buckets idle long enough to be full again are
+// dropped. A missing bucket behaves like a full one.
+const evictInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	window time.Duration
+}
+
+// MemoryStore keeps buckets in process. Every instance counts on its own.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{buckets: make(map[string]*bucket)}
+	go s.evictIdle()
+	return s
+}
+
+func (s *MemoryStore) evictIdle() {
+	for range time.Tick(evictInterval) {
+		now := time.Now()
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if now.Sub(b.last) >= b.window {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	return s.take(key, limit, window, time.Now()), nil
+}
+
+func (s *MemoryStore) take(key string, limit int, window time.Duration, now time.Time) Result {
+	capacity := float64(limit)
+	perToken := window / time.Duration(limit)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now, window: window}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+
+	result := Result{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((capacity - b.tokens) * float64(perToken))
+	return result
+}
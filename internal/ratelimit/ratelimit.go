@@ -0,0 +1,40 @@
+// Package ratelimit keeps the token buckets of the API rate limiter, either
+// in process or in Redis so that several replicas share their limits.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Result is the state of a bucket after a request took from it.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero when
+	// this one was.
+	RetryAfter time.Duration
+}
+
+// Store takes a token from the bucket key, which holds limit tokens and
+// refills completely over window. Buckets that do not exist yet are full.
+type Store interface {
+	Take(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+// FromEnv picks a store from RATE_LIMIT_STORE: "memory" (the default, for a
+// single instance) or "redis", which connects to RATE_LIMIT_REDIS_URL.
+func FromEnv() (Store, error) {
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "redis":
+		return NewRedisStore(os.Getenv("RATE_LIMIT_REDIS_URL"))
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", store)
+	}
+}
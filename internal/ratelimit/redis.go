@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenBucketScript takes a token from the bucket in KEYS[1] atomically.
+// ARGV[1] is the limit and ARGV[2] the window in milliseconds. The clock is
+// the server's, so replicas with skewed clocks still agree. It returns
+// {allowed, remaining, reset ms, retry after ms}.
+const TokenBucketScript = `
+redis.replicate_commands()
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local per_token = window / limit
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1]) or limit
+local last = tonumber(state[2]) or now
+tokens = math.min(limit, tokens + math.max(0, now - last) / per_token)
+
+local allowed, retry = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) * per_token)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, math.floor(tokens), math.ceil((limit - tokens) * per_token), retry}
+`
+
+// TokenBucketScriptSHA is what EVALSHA knows the script by.
+var TokenBucketScriptSHA = func() string {
+	sum := sha1.Sum([]byte(TokenBucketScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+const (
+	redisKeyPrefix   = "ratelimit:"
+	redisTimeout     = time.Second
+	redisMaxIdleConn = 16
+)
+
+// RedisStore keeps buckets in Redis, shared by every instance using the same
+// server. Each take is a single script call, so concurrent requests on
+// different instances cannot both spend the last token.
+type RedisStore struct {
+	addr     string
+	useTLS   bool
+	username string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	idle []*respConn
+}
+
+// NewRedisStore connects to a redis:// or rediss:// URL such as
+// redis://:password@localhost:6379/0.
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Redis URL %q", rawURL)
+	}
+
+	s := &RedisStore{addr: u.Host, useTLS: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+
+	// fail at startup rather than on the first request
+	conn, err := s.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	s.put(conn)
+	return s, nil
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	conn, err := s.get(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	args := []string{TokenBucketScriptSHA, "1", redisKeyPrefix + key, strconv.Itoa(limit), strconv.FormatInt(window.Milliseconds(), 10)}
+	reply, err := conn.do(append([]string{"EVALSHA"}, args...)...)
+
+	// the script cache is empty after a restart, EVAL loads it again
+	var redisErr RedisError
+	if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT") {
+		args[0] = TokenBucketScript
+		reply, err = conn.do(append([]string{"EVAL"}, args...)...)
+	}
+	if err != nil {
+		// an error reply leaves the connection usable, anything else may
+		// have left half a reply on it
+		if errors.As(err, &redisErr) {
+			s.put(conn)
+		} else {
+			conn.conn.Close()
+		}
+		return Result{}, fmt.Errorf("redis: %w", err)
+	}
+	s.put(conn)
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 4 {
+		return Result{}, fmt.Errorf("redis: unexpected script reply %v", reply)
+	}
+	var n [4]int64
+	for i, v := range values {
+		if n[i], ok = v.(int64); !ok {
+			return Result{}, fmt.Errorf("redis: unexpected script reply %v", reply)
+		}
+	}
+
+	return Result{
+		Allowed:    n[0] == 1,
+		Remaining:  int(n[1]),
+		Reset:      time.Duration(n[2]) * time.Millisecond,
+		RetryAfter: time.Duration(n[3]) * time.Millisecond,
+	}, nil
+}
+
+// get returns an idle connection or dials a new one. The deadline of every
+// command is set here.
+func (s *RedisStore) get(ctx context.Context) (*respConn, error) {
+	deadline := time.Now().Add(redisTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		conn.conn.SetDeadline(deadline)
+		return conn, nil
+	}
+	s.mu.Unlock()
+
+	dialer := &net.Dialer{Deadline: deadline}
+	var raw net.Conn
+	var err error
+	if s.useTLS {
+		raw, err = tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{MinVersion: tls.VersionTLS12})
+	} else {
+		raw, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	raw.SetDeadline(deadline)
+	conn := newRespConn(raw)
+
+	if s.password != "" {
+		args := []string{"AUTH", s.password}
+		if s.username != "" {
+			args = []string{"AUTH", s.username, s.password}
+		}
+		if _, err := conn.do(args...); err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+	}
+	if s.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(s.db)); err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (s *RedisStore) put(conn *respConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.idle) >= redisMaxIdleConn {
+		conn.conn.Close()
+		return
+	}
+	s.idle = append(s.idle, conn)
+}
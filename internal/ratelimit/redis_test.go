@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// The store runs the real TokenBucketScript, miniredis executes it with an
+// embedded Lua interpreter.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *RedisStore) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	m.SetTime(time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC))
+
+	store, err := NewRedisStore("redis://" + m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, store
+}
+
+func TestRedisStoreTokenBucket(t *testing.T) {
+	m, store := newTestRedis(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		result, err := store.Take(ctx, "client", 3, 3*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("take %d = %+v, want allowed with %d remaining", i, result, 2-i)
+		}
+	}
+
+	result, err := store.Take(ctx, "client", 3, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Fatalf("take on an empty bucket = %+v, want denied, retry after 1s, reset 3s", result)
+	}
+
+	// a token comes back every window/limit
+	m.SetTime(time.Date(2026, 1, 5, 8, 0, 1, 0, time.UTC))
+	result, err = store.Take(ctx, "client", 3, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("take after refill = %+v, want allowed with 0 remaining", result)
+	}
+
+	if ttl := m.TTL("ratelimit:client"); ttl != 3*time.Second {
+		t.Fatalf("bucket TTL = %v, want the window", ttl)
+	}
+	if result, _ := store.Take(ctx, "other", 3, 3*time.Second); result.Remaining != 2 {
+		t.Fatalf("other client = %+v, want its own bucket", result)
+	}
+}
+
+func TestRedisStoreReloadsScript(t *testing.T) {
+	_, store := newTestRedis(t)
+	ctx := context.Background()
+
+	if _, err := store.Take(ctx, "client", 5, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// like a restarted server, EVALSHA now fails with NOSCRIPT
+	conn, err := store.get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.do("SCRIPT", "FLUSH"); err != nil {
+		t.Fatal(err)
+	}
+	store.put(conn)
+	if _, err := conn.do("EVALSHA", TokenBucketScriptSHA, "1", "x", "1", "1000"); err == nil || !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		t.Fatalf("EVALSHA after flush: err = %v, want NOSCRIPT", err)
+	}
+
+	if _, err := store.Take(ctx, "client", 5, time.Minute); err != nil {
+		t.Fatalf("take after script flush: %v", err)
+	}
+}
+
+func TestRedisStoreAuth(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.RequireAuth("secret")
+
+	if _, err := NewRedisStore("redis://:wrong@" + m.Addr()); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Fatalf("wrong password: err = %v, want WRONGPASS", err)
+	}
+
+	store, err := NewRedisStore("redis://:secret@" + m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := store.Take(context.Background(), "client", 1, time.Second); err != nil || !result.Allowed {
+		t.Fatalf("take = %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteCommand(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeCommand(w, []string{"EVALSHA", "abc", "1", "ratelimit:k"})
+	w.Flush()
+
+	want := "*4\r\n$7\r\nEVALSHA\r\n$3\r\nabc\r\n$1\r\n1\r\n$11\r\nratelimit:k\r\n"
+	if buf.String() != want {
+		t.Fatalf("writeCommand wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  any
+		err   error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"integer", ":-42\r\n", int64(-42), nil},
+		{"bulk string", "$5\r\nhello\r\n", "hello", nil},
+		{"bulk string with CRLF", "$4\r\na\r\nb\r\n", "a\r\nb", nil},
+		{"nil bulk string", "$-1\r\n", nil, nil},
+		{"error", "-NOSCRIPT No matching script.\r\n", nil, RedisError("NOSCRIPT No matching script.")},
+		{"script reply", "*4\r\n:1\r\n:4\r\n:12000\r\n:0\r\n", []any{int64(1), int64(4), int64(12000), int64(0)}, nil},
+		{"nested array with error", "*2\r\n*1\r\n+a\r\n-ERR b\r\n", []any{[]any{"a"}, RedisError("ERR b")}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if !errors.Is(err, tt.err) && err != tt.err {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("reply = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadReplyMalformed(t *testing.T) {
+	for _, input := range []string{"", "+OK\n", "$x\r\n", "$5\r\nab\r\n", "*2\r\n:1\r\n", "?\r\n"} {
+		if _, err := readReply(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("readReply(%q) succeeded, want an error", input)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Package cache decorates repositories with in-process read-through caches.
+// Entries are kept per tenant, least recently used ones are evicted beyond
+// the configured size and all of them expire after a TTL, which bounds how
+// long writes of other replicas stay unseen.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// Stats are the counters of a cache since it was created.
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+}
+
+type lruEntry[V any] struct {
+	key       string
+	group     string
+	value     V
+	expiresAt time.Time
+}
+
+// lru maps keys to values, evicting the least recently used beyond size.
+// Keys belong to a group, e.g. every key of one teacher, which can be
+// removed at once.
+type lru[V any] struct {
+	size int
+	ttl  time.Duration
+
+	mu     sync.Mutex
+	order  *list.List
+	items  map[string]*list.Element
+	groups map[string]map[string]struct{}
+	// generation counts removals, a value loaded across one is not added
+	generation uint64
+
+	hits, misses, evictions, invalidations atomic.Uint64
+}
+
+func newLRU[V any](size int, ttl time.Duration) *lru[V] {
+	return &lru[V]{
+		size:   size,
+		ttl:    ttl,
+		order:  list.New(),
+		items:  map[string]*list.Element{},
+		groups: map[string]map[string]struct{}{},
+	}
+}
+
+// get returns the value of key, and otherwise the generation to pass to
+// add.
+func (c *lru[V]) get(key string) (V, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry[V])
+		if time.Now().Before(entry.expiresAt) {
+			c.order.MoveToFront(el)
+			c.hits.Add(1)
+			return entry.value, 0, true
+		}
+		c.removeElement(el)
+	}
+
+	c.misses.Add(1)
+	var zero V
+	return zero, c.generation, false
+}
+
+func (c *lru[V]) add(key, group string, value V, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	entry := &lruEntry[V]{key: key, group: group, value: value, expiresAt: time.Now().Add(c.ttl)}
+	c.items[key] = c.order.PushFront(entry)
+	if c.groups[group] == nil {
+		c.groups[group] = map[string]struct{}{}
+	}
+	c.groups[group][key] = struct{}{}
+
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// removeGroup drops every key of group.
+func (c *lru[V]) removeGroup(group string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.invalidations.Add(1)
+	for key := range c.groups[group] {
+		c.removeElement(c.items[key])
+	}
+}
+
+func (c *lru[V]) removeElement(el *list.Element) {
+	entry := c.order.Remove(el).(*lruEntry[V])
+	delete(c.items, entry.key)
+	delete(c.groups[entry.group], entry.key)
+	if len(c.groups[entry.group]) == 0 {
+		delete(c.groups, entry.group)
+	}
+}
+
+func (c *lru[V]) stats() Stats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       entries,
+	}
+}
+
+// flight is a load in progress that callers for the same key wait for.
+type flight[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// singleflight runs one load per key at a time, concurrent misses share
+// its result instead of all querying the database.
+type singleflight[V any] struct {
+	mu      sync.Mutex
+	flights map[string]*flight[V]
+}
+
+// do returns the result of the load of key in progress, or starts load. The
+// load runs on its own with ctx's values but not its cancellation, so
+// callers giving up, the one that started it included, fail alone. A panic
+// of load fails every caller instead of handing them a zero value.
+func (g *singleflight[V]) do(ctx context.Context, key string, load func(context.Context) (V, error)) (V, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		if g.flights == nil {
+			g.flights = map[string]*flight[V]{}
+		}
+		f = &flight[V]{done: make(chan struct{})}
+		g.flights[key] = f
+
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					f.err = utils.ErrorHandler(fmt.Errorf("panic: %v\n%s", v, debug.Stack()), "Error loading from the database.")
+				}
+				g.mu.Lock()
+				delete(g.flights, key)
+				g.mu.Unlock()
+				close(f.done)
+			}()
+			f.value, f.err = load(context.WithoutCancel(ctx))
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero V
+		return zero, utils.UnavailableError(ctx.Err(), "Request cancelled.")
+	}
+}
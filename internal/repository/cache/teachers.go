@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/internal/repository"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// Options sizes a cache. A Size of 0 disables it.
+type Options struct {
+	Size int
+	TTL  time.Duration
+}
+
+// OptionsFromEnv reads REPOSITORY_CACHE_SIZE, the entries per repository
+// (unset or 0 to disable caching), and REPOSITORY_CACHE_TTL (default 1m).
+func OptionsFromEnv() (Options, error) {
+	options := Options{TTL: utils.DurationFromEnv("REPOSITORY_CACHE_TTL", time.Minute)}
+	if size := os.Getenv("REPOSITORY_CACHE_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 {
+			return Options{}, fmt.Errorf("invalid REPOSITORY_CACHE_SIZE %q", size)
+		}
+		options.Size = n
+	}
+	return options, nil
+}
+
+// Teachers caches the lookups of single teachers by ID and email. Lists
+// depend on filters, sorting and the caller's scope and are not cached.
+// Every write drops the teachers it touched, errors included, since a
+// failed bulk write may have applied in part.
+type Teachers struct {
+	next    repository.TeacherRepository
+	entries *lru[models.Teacher]
+	loads   singleflight[models.Teacher]
+}
+
+func NewTeachers(next repository.TeacherRepository, options Options) *Teachers {
+	return &Teachers{next: next, entries: newLRU[models.Teacher](options.Size, options.TTL)}
+}
+
+func (t *Teachers) Stats() Stats {
+	return t.entries.stats()
+}
+
+func teacherGroup(ctx context.Context, id int) string {
+	return utils.TenantSlug(ctx) + "/" + strconv.Itoa(id)
+}
+
+// lookup serves key from the cache or loads it once for all concurrent
+// callers. Misses, not found included, are not cached.
+func (t *Teachers) lookup(ctx context.Context, key string, load func(context.Context) (models.Teacher, error)) (models.Teacher, error) {
+	key = utils.TenantSlug(ctx) + "/" + key
+	teacher, generation, ok := t.entries.get(key)
+	if ok {
+		return teacher, nil
+	}
+
+	return t.loads.do(ctx, key, func(ctx context.Context) (models.Teacher, error) {
+		teacher, err := load(ctx)
+		if err == nil {
+			t.entries.add(key, teacherGroup(ctx, teacher.ID), teacher, generation)
+		}
+		return teacher, err
+	})
+}
+
+func (t *Teachers) invalidate(ctx context.Context, ids ...int) {
+	for _, id := range ids {
+		t.entries.removeGroup(teacherGroup(ctx, id))
+	}
+}
+
+func (t *Teachers) GetTeachers(r *http.Request) ([]models.Teacher, error) {
+	return t.next.GetTeachers(r)
+}
+
+func (t *Teachers) GetTeacherById(ctx context.Context, id int) (models.Teacher, error) {
+	return t.lookup(ctx, "id:"+strconv.Itoa(id), func(ctx context.Context) (models.Teacher, error) {
+		return t.next.GetTeacherById(ctx, id)
+	})
+}
+
+func (t *Teachers) GetTeacherByEmail(ctx context.Context, email string) (models.Teacher, error) {
+	return t.lookup(ctx, "email:"+email, func(ctx context.Context) (models.Teacher, error) {
+		return t.next.GetTeacherByEmail(ctx, email)
+	})
+}
+
+// AddTeachers drops nothing, only found teachers are cached.
+func (t *Teachers) AddTeachers(ctx context.Context, teachers []models.Teacher) ([]models.Teacher, error) {
+	return t.next.AddTeachers(ctx, teachers)
+}
+
+func (t *Teachers) UpdateTeacher(ctx context.Context, id int, teacher models.Teacher) (models.Teacher, error) {
+	defer t.invalidate(ctx, id)
+	return t.next.UpdateTeacher(ctx, id, teacher)
+}
+
+func (t *Teachers) PatchTeacher(ctx context.Context, id int, updates map[string]any) (models.Teacher, error) {
+	defer t.invalidate(ctx, id)
+	return t.next.PatchTeacher(ctx, id, updates)
+}
+
+func (t *Teachers) PatchTeachers(ctx context.Context, updates []map[string]any) error {
+	var ids []int
+	for _, update := range updates {
+		// IDs the repository cannot parse fail the whole patch
+		if id, ok := utils.JSONID(update["id"]); ok {
+			ids = append(ids, id)
+		}
+	}
+	defer t.invalidate(ctx, ids...)
+	return t.next.PatchTeachers(ctx, updates)
+}
+
+func (t *Teachers) DeleteTeacher(ctx context.Context, id int) error {
+	defer t.invalidate(ctx, id)
+	return t.next.DeleteTeacher(ctx, id)
+}
+
+func (t *Teachers) DeleteTeachers(ctx context.Context, ids []int) ([]int, error) {
+	defer t.invalidate(ctx, ids...)
+	return t.next.DeleteTeachers(ctx, ids)
+}
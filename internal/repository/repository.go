@@ -0,0 +1,24 @@
+// Package repository declares the data access the handlers depend on, so
+// the SQL implementation in sqlconnect can be decorated, e.g. with the
+// caches in repository/cache.
+package repository
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+)
+
+// TeacherRepository reads and writes the teachers of the request's tenant.
+type TeacherRepository interface {
+	GetTeachers(r *http.Request) ([]models.Teacher, error)
+	GetTeacherById(ctx context.Context, id int) (models.Teacher, error)
+	GetTeacherByEmail(ctx context.Context, email string) (models.Teacher, error)
+	AddTeachers(ctx context.Context, teachers []models.Teacher) ([]models.Teacher, error)
+	UpdateTeacher(ctx context.Context, id int, teacher models.Teacher) (models.Teacher, error)
+	PatchTeacher(ctx context.Context, id int, updates map[string]any) (models.Teacher, error)
+	PatchTeachers(ctx context.Context, updates []map[string]any) error
+	DeleteTeacher(ctx context.Context, id int) error
+	DeleteTeachers(ctx context.Context, ids []int) ([]int, error)
+}
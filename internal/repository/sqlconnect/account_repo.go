@@ -0,0 +1,316 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+const accountColumns = "id, username, email, password_hash, role, teacher_id, student_id, email_verified_at, totp_enabled_at, created_at"
+
+func scanAccount(row interface{ Scan(...any) error }, account *models.Account) error {
+	var teacherId, studentId sql.NullInt64
+	var emailVerifiedAt, totpEnabledAt sql.NullTime
+	err := row.Scan(&account.ID, &account.Username, &account.Email, &account.PasswordHash, &account.Role, &teacherId, &studentId, &emailVerifiedAt, &totpEnabledAt, &account.CreatedAt)
+	if err != nil {
+		return err
+	}
+	account.TeacherID = nullableInt(teacherId)
+	account.StudentID = nullableInt(studentId)
+	account.EmailVerifiedAt = nullableTime(emailVerifiedAt)
+	account.TOTPEnabledAt = nullableTime(totpEnabledAt)
+	return nil
+}
+
+func nullableInt(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
+
+func nullableTime(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Time
+}
+
+func GetAccountByUsernameDB(ctx context.Context, username string) (models.Account, error) {
+	return getAccountDB(ctx, "SELECT "+accountColumns+" FROM accounts WHERE username = ?", username)
+}
+
+func GetAccountByEmailDB(ctx context.Context, email string) (models.Account, error) {
+	return getAccountDB(ctx, "SELECT "+accountColumns+" FROM accounts WHERE email = ?", email)
+}
+
+func GetAccountByIdDB(ctx context.Context, id int) (models.Account, error) {
+	return getAccountDB(ctx, "SELECT "+accountColumns+" FROM accounts WHERE id = ?", id)
+}
+
+func getAccountDB(ctx context.Context, query string, args ...any) (models.Account, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.Account{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var account models.Account
+	err = scanAccount(db.QueryRow(query, args...), &account)
+	if err == sql.ErrNoRows {
+		return models.Account{}, utils.NotFoundError(err, "Account not found.")
+	} else if err != nil {
+		return models.Account{}, utils.ErrorHandler(err, "Database query error.")
+	}
+	return account, nil
+}
+
+func GetAccountsDB(ctx context.Context) ([]models.Account, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	rows, err := db.Query("SELECT " + accountColumns + " FROM accounts ORDER BY id")
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+	defer rows.Close()
+
+	accounts := []models.Account{}
+	for rows.Next() {
+		var account models.Account
+		if err := scanAccount(rows, &account); err != nil {
+			return nil, utils.ErrorHandler(err, "Database scanning db results.")
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// AddAccountDB stores a new account. PasswordHash must already be set.
+func AddAccountDB(ctx context.Context, account models.Account) (models.Account, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.Account{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	resp, err := db.Exec("INSERT INTO accounts (username, email, password_hash, role, teacher_id, student_id) VALUES (?,?,?,?,?,?)",
+		account.Username, account.Email, account.PasswordHash, account.Role, account.TeacherID, account.StudentID)
+	if err != nil {
+		return models.Account{}, utils.ErrorHandler(err, "Error inserting account into DB.")
+	}
+
+	newId, err := resp.LastInsertId()
+	if err != nil {
+		return models.Account{}, utils.ErrorHandler(err, "Error getting newly created ID.")
+	}
+	account.ID = int(newId)
+	account.Password = ""
+	return account, nil
+}
+
+// BootstrapExecAccountDB creates the first exec account from
+// BOOTSTRAP_EXEC_USERNAME, BOOTSTRAP_EXEC_EMAIL and BOOTSTRAP_EXEC_PASSWORD
+// when the accounts table is still empty, so a fresh install can log in.
+func BootstrapExecAccountDB(ctx context.Context) error {
+	username := os.Getenv("BOOTSTRAP_EXEC_USERNAME")
+	password := os.Getenv("BOOTSTRAP_EXEC_PASSWORD")
+	if username == "" || password == "" {
+		return nil
+	}
+
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM accounts").Scan(&count)
+	if err != nil {
+		return utils.ErrorHandler(err, "Database query error.")
+	}
+	if count > 0 {
+		return nil
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error hashing password.")
+	}
+
+	_, err = AddAccountDB(ctx, models.Account{
+		Username:     username,
+		Email:        os.Getenv("BOOTSTRAP_EXEC_EMAIL"),
+		PasswordHash: hash,
+		Role:         "exec",
+	})
+	return err
+}
+
+func AddRefreshTokenDB(ctx context.Context, accountId int, tokenHash, familyId string, expiresAt time.Time) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("INSERT INTO refresh_tokens (account_id, token_hash, family_id, expires_at) VALUES (?,?,?,?)",
+		accountId, tokenHash, familyId, expiresAt.UTC())
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing refresh token.")
+	}
+	return nil
+}
+
+// RotateRefreshTokenDB revokes the presented refresh token and stores its
+// successor in the same family. Presenting an already revoked token means it
+// was stolen or replayed, so the whole family is revoked.
+func RotateRefreshTokenDB(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (models.Account, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.Account{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Account{}, utils.ErrorHandler(err, "Error starting transaction.")
+	}
+
+	var accountId int
+	var familyId string
+	var tokenExpiresAt time.Time
+	var revokedAt sql.NullTime
+	err = tx.QueryRow("SELECT account_id, family_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = ? FOR UPDATE", oldHash).
+		Scan(&accountId, &familyId, &tokenExpiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return models.Account{}, utils.UnauthorizedError(err, "Invalid refresh token.")
+	} else if err != nil {
+		tx.Rollback()
+		return models.Account{}, utils.ErrorHandler(err, "Database query error.")
+	}
+
+	if revokedAt.Valid {
+		_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE family_id = ? AND revoked_at IS NULL", familyId)
+		if err != nil {
+			tx.Rollback()
+			return models.Account{}, utils.ErrorHandler(err, "Error revoking refresh tokens.")
+		}
+		if err = tx.Commit(); err != nil {
+			return models.Account{}, utils.ErrorHandler(err, "Could not commit changes.")
+		}
+		return models.Account{}, utils.UnauthorizedError(errors.New("refresh token reuse detected"), "Invalid refresh token.")
+	}
+
+	if time.Now().After(tokenExpiresAt) {
+		tx.Rollback()
+		return models.Account{}, utils.UnauthorizedError(nil, "Refresh token expired.")
+	}
+
+	_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE token_hash = ?", oldHash)
+	if err != nil {
+		tx.Rollback()
+		return models.Account{}, utils.ErrorHandler(err, "Error revoking refresh token.")
+	}
+
+	_, err = tx.Exec("INSERT INTO refresh_tokens (account_id, token_hash, family_id, expires_at) VALUES (?,?,?,?)",
+		accountId, newHash, familyId, expiresAt.UTC())
+	if err != nil {
+		tx.Rollback()
+		return models.Account{}, utils.ErrorHandler(err, "Error storing refresh token.")
+	}
+
+	var account models.Account
+	err = scanAccount(tx.QueryRow("SELECT "+accountColumns+" FROM accounts WHERE id = ?", accountId), &account)
+	if err != nil {
+		tx.Rollback()
+		return models.Account{}, utils.ErrorHandler(err, "Database query error.")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Account{}, utils.ErrorHandler(err, "Could not commit changes.")
+	}
+	return account, nil
+}
+
+func RevokeRefreshTokenDB(ctx context.Context, accountId int, tokenHash string) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE account_id = ? AND token_hash = ? AND revoked_at IS NULL", accountId, tokenHash)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error revoking refresh token.")
+	}
+	return nil
+}
+
+func RevokeAccessTokenDB(ctx context.Context, jti string, expiresAt time.Time) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	// drop entries whose tokens would be rejected as expired anyway
+	_, err = db.Exec("DELETE FROM revoked_access_tokens WHERE expires_at < UTC_TIMESTAMP()")
+	if err != nil {
+		return utils.ErrorHandler(err, "Error pruning revoked tokens.")
+	}
+
+	_, err = db.Exec("INSERT IGNORE INTO revoked_access_tokens (jti, expires_at) VALUES (?,?)", jti, expiresAt.UTC())
+	if err != nil {
+		return utils.ErrorHandler(err, "Error revoking access token.")
+	}
+	return nil
+}
+
+func IsAccessTokenRevokedDB(ctx context.Context, jti string) (bool, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return false, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM revoked_access_tokens WHERE jti = ?", jti).Scan(&count)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Database query error.")
+	}
+	return count > 0, nil
+}
+
+// AccountPrincipalDB resolves the records a teacher or student account is
+// linked to, so that ownership checks need no extra lookups per request.
+func AccountPrincipalDB(ctx context.Context, account models.Account) (utils.Principal, error) {
+	principal := utils.Principal{
+		AccountID: account.ID,
+		Username:  account.Username,
+		Role:      account.Role,
+		Tenant:    utils.TenantSlug(ctx),
+	}
+
+	if account.TeacherID != nil {
+		teacher, err := GetTeacherByIdDB(ctx, *account.TeacherID)
+		if err != nil {
+			return principal, err
+		}
+		principal.TeacherID = teacher.ID
+		principal.Class = teacher.Class
+	}
+
+	if account.StudentID != nil {
+		student, err := GetStudentByIdDB(ctx, *account.StudentID)
+		if err != nil {
+			return principal, err
+		}
+		principal.StudentID = student.ID
+		principal.Class = student.Class
+	}
+
+	return principal, nil
+}
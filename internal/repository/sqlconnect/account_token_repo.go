@@ -0,0 +1,139 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeEmailVerify   = "email_verify"
+)
+
+func CountRecentAccountTokensDB(ctx context.Context, accountId int, purpose string, since time.Time) (int, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return 0, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM account_tokens WHERE account_id = ? AND purpose = ? AND created_at >= ?", accountId, purpose, since.UTC()).Scan(&count)
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Database query error.")
+	}
+	return count, nil
+}
+
+func AddAccountTokenDB(ctx context.Context, accountId int, purpose, tokenHash string, expiresAt time.Time) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("INSERT INTO account_tokens (account_id, purpose, token_hash, expires_at) VALUES (?,?,?,?)", accountId, purpose, tokenHash, expiresAt.UTC())
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing token.")
+	}
+	return nil
+}
+
+// ResetPasswordDB consumes a password reset token, stores the new hash and
+// revokes every refresh token and cookie session of the account so other
+// sessions end.
+func ResetPasswordDB(ctx context.Context, tokenHash, passwordHash string) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error starting transaction.")
+	}
+
+	accountId, err := consumeAccountToken(tx, TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE accounts SET password_hash = ? WHERE id = ?", passwordHash, accountId)
+	if err != nil {
+		tx.Rollback()
+		return utils.ErrorHandler(err, "Error updating password.")
+	}
+
+	_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE account_id = ? AND revoked_at IS NULL", accountId)
+	if err != nil {
+		tx.Rollback()
+		return utils.ErrorHandler(err, "Error revoking refresh tokens.")
+	}
+
+	_, err = tx.Exec("UPDATE sessions SET revoked_at = UTC_TIMESTAMP() WHERE account_id = ? AND revoked_at IS NULL", accountId)
+	if err != nil {
+		tx.Rollback()
+		return utils.ErrorHandler(err, "Error revoking sessions.")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "Could not commit changes.")
+	}
+	return nil
+}
+
+func VerifyEmailDB(ctx context.Context, tokenHash string) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error starting transaction.")
+	}
+
+	accountId, err := consumeAccountToken(tx, TokenPurposeEmailVerify, tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE accounts SET email_verified_at = UTC_TIMESTAMP() WHERE id = ?", accountId)
+	if err != nil {
+		tx.Rollback()
+		return utils.ErrorHandler(err, "Error verifying email.")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "Could not commit changes.")
+	}
+	return nil
+}
+
+// consumeAccountToken marks a token as used and returns its account. Unknown,
+// used and expired tokens are all reported the same way.
+func consumeAccountToken(tx *sql.Tx, purpose, tokenHash string) (int, error) {
+	var id, accountId int
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err := tx.QueryRow("SELECT id, account_id, expires_at, used_at FROM account_tokens WHERE token_hash = ? AND purpose = ? FOR UPDATE", tokenHash, purpose).
+		Scan(&id, &accountId, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return 0, utils.ValidationError(err, "Invalid or expired token.")
+	} else if err != nil {
+		return 0, utils.ErrorHandler(err, "Database query error.")
+	}
+
+	if usedAt.Valid || time.Now().After(expiresAt) {
+		return 0, utils.ValidationError(nil, "Invalid or expired token.")
+	}
+
+	_, err = tx.Exec("UPDATE account_tokens SET used_at = UTC_TIMESTAMP() WHERE id = ?", id)
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error consuming token.")
+	}
+	return accountId, nil
+}
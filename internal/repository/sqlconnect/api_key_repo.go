@@ -0,0 +1,128 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+const apiKeyColumns = "id, account_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at"
+
+func scanApiKey(row interface{ Scan(...any) error }, key *models.ApiKey) error {
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.AccountID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &expiresAt, &lastUsedAt, &revokedAt, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	key.ExpiresAt = nullableTime(expiresAt)
+	key.LastUsedAt = nullableTime(lastUsedAt)
+	key.RevokedAt = nullableTime(revokedAt)
+	return nil
+}
+
+func GetApiKeysDB(ctx context.Context) ([]models.ApiKey, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	rows, err := db.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+	defer rows.Close()
+
+	keys := []models.ApiKey{}
+	for rows.Next() {
+		var key models.ApiKey
+		if err := scanApiKey(rows, &key); err != nil {
+			return nil, utils.ErrorHandler(err, "Database scanning db results.")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func GetApiKeyByPrefixDB(ctx context.Context, prefix string) (models.ApiKey, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.ApiKey{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var key models.ApiKey
+	err = scanApiKey(db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = ?", prefix), &key)
+	if err == sql.ErrNoRows {
+		return models.ApiKey{}, utils.NotFoundError(err, "API key not found.")
+	} else if err != nil {
+		return models.ApiKey{}, utils.ErrorHandler(err, "Database query error.")
+	}
+	return key, nil
+}
+
+// AddApiKeyDB stores a new key. Prefix and KeyHash must already be set.
+func AddApiKeyDB(ctx context.Context, key models.ApiKey) (models.ApiKey, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.ApiKey{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var expiresAt any
+	if key.ExpiresAt != nil {
+		expiresAt = key.ExpiresAt.UTC()
+	}
+
+	resp, err := db.Exec("INSERT INTO api_keys (account_id, name, prefix, key_hash, scopes, expires_at) VALUES (?,?,?,?,?,?)",
+		key.AccountID, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, ","), expiresAt)
+	if err != nil {
+		return models.ApiKey{}, utils.ErrorHandler(err, "Error inserting API key into DB.")
+	}
+
+	newId, err := resp.LastInsertId()
+	if err != nil {
+		return models.ApiKey{}, utils.ErrorHandler(err, "Error getting newly created ID.")
+	}
+	key.ID = int(newId)
+	return key, nil
+}
+
+func RevokeApiKeyDB(ctx context.Context, id int) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	result, err := db.Exec("UPDATE api_keys SET revoked_at = UTC_TIMESTAMP() WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return utils.ErrorHandler(err, "Could not revoke API key.")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error retrieving revoke result.")
+	}
+
+	if rowsAffected == 0 {
+		return utils.NotFoundError(err, "API key not found or already revoked.")
+	}
+	return nil
+}
+
+// TouchApiKeyDB records that a key was used. The timestamp is only written
+// once a minute to keep busy clients from turning every request into a write.
+func TouchApiKeyDB(ctx context.Context, id int) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("UPDATE api_keys SET last_used_at = UTC_TIMESTAMP() WHERE id = ? AND (last_used_at IS NULL OR last_used_at < UTC_TIMESTAMP() - INTERVAL 1 MINUTE)", id)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error updating API key usage.")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+const auditColumns = "id, event, account_id, actor_account_id, ip, detail, created_at"
+
+func AddAuditEventDB(ctx context.Context, event models.AuditEvent) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	// details may quote request input, cut them to the column size
+	detail := []rune(event.Detail)
+	if len(detail) > 255 {
+		detail = detail[:255]
+	}
+
+	_, err = db.Exec("INSERT INTO audit_log (event, account_id, actor_account_id, ip, detail) VALUES (?,?,?,?,?)",
+		event.Event, event.AccountID, event.ActorAccountID, event.IP, string(detail))
+	if err != nil {
+		return utils.ErrorHandler(err, "Error writing audit log.")
+	}
+	return nil
+}
+
+// GetAuditEventsDB returns the newest events first, filtered by the event and
+// account_id query parameters and capped by limit (default and max 500).
+func GetAuditEventsDB(r *http.Request) ([]models.AuditEvent, error) {
+	query := "SELECT " + auditColumns + " FROM audit_log WHERE 1=1"
+	var args []any
+
+	if event := utils.Params(r).Get("event"); event != "" {
+		query += " AND event = ?"
+		args = append(args, event)
+	}
+	if accountId := utils.Params(r).Get("account_id"); accountId != "" {
+		id, err := strconv.Atoi(accountId)
+		if err != nil {
+			return nil, utils.InvalidFieldsError([]utils.FieldError{{Field: "account_id", Message: "must be a number"}})
+		}
+		query += " AND account_id = ?"
+		args = append(args, id)
+	}
+
+	limit, err := strconv.Atoi(utils.Params(r).Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 500
+	}
+	query += " ORDER BY id DESC LIMIT " + strconv.Itoa(limit)
+
+	db, err := TenantDB(r.Context())
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		var accountId, actorId sql.NullInt64
+		err := rows.Scan(&event.ID, &event.Event, &accountId, &actorId, &event.IP, &event.Detail, &event.CreatedAt)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "Database scanning db results.")
+		}
+		event.AccountID = nullableInt(accountId)
+		event.ActorAccountID = nullableInt(actorId)
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,101 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// GetLoginLockDB returns until when subject is locked out, or nil.
+func GetLoginLockDB(ctx context.Context, subject string) (*time.Time, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var lockedUntil sql.NullTime
+	err = db.QueryRow("SELECT locked_until FROM login_failures WHERE subject = ?", subject).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+
+	if !lockedUntil.Valid || time.Now().After(lockedUntil.Time) {
+		return nil, nil
+	}
+	return &lockedUntil.Time, nil
+}
+
+// AddLoginFailureDB counts a failed login of subject and returns the number
+// of failures in a row. The count starts over when the subject had no
+// failures, and was not locked, for the last window.
+func AddLoginFailureDB(ctx context.Context, subject string, window time.Duration) (int, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return 0, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, utils.ErrorHandler(err, "Error starting transaction.")
+	}
+
+	// failures is assigned first so it still sees the old timestamps
+	_, err = tx.Exec(`INSERT INTO login_failures (subject, failures, last_failure_at) VALUES (?, 1, UTC_TIMESTAMP())
+		ON DUPLICATE KEY UPDATE
+			failures = IF(GREATEST(last_failure_at, COALESCE(locked_until, last_failure_at)) < ?, 1, failures + 1),
+			last_failure_at = UTC_TIMESTAMP()`,
+		subject, time.Now().Add(-window).UTC())
+	if err != nil {
+		tx.Rollback()
+		return 0, utils.ErrorHandler(err, "Error recording failed login.")
+	}
+
+	var failures int
+	err = tx.QueryRow("SELECT failures FROM login_failures WHERE subject = ?", subject).Scan(&failures)
+	if err != nil {
+		tx.Rollback()
+		return 0, utils.ErrorHandler(err, "Database query error.")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, utils.ErrorHandler(err, "Could not commit changes.")
+	}
+	return failures, nil
+}
+
+func LockLoginDB(ctx context.Context, subject string, until time.Time) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("UPDATE login_failures SET locked_until = ? WHERE subject = ?", until.UTC(), subject)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error locking login.")
+	}
+	return nil
+}
+
+// ClearLoginFailuresDB forgets the failures of subject and lifts its lock.
+// It reports whether there was anything to clear.
+func ClearLoginFailuresDB(ctx context.Context, subject string) (bool, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return false, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	result, err := db.Exec("DELETE FROM login_failures WHERE subject = ?", subject)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error clearing failed logins.")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error retrieving delete result.")
+	}
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,67 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+func AddOIDCLoginDB(ctx context.Context, stateHash, nonce, codeVerifier string, expiresAt time.Time) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	// logins that were never completed are dropped on the way
+	_, err = db.Exec("DELETE FROM oidc_logins WHERE expires_at < UTC_TIMESTAMP()")
+	if err != nil {
+		return utils.ErrorHandler(err, "Error deleting expired logins.")
+	}
+
+	_, err = db.Exec("INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at) VALUES (?,?,?,?)", stateHash, nonce, codeVerifier, expiresAt.UTC())
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing login state.")
+	}
+	return nil
+}
+
+// ConsumeOIDCLoginDB returns the nonce and PKCE verifier of a pending login
+// and deletes it, so every state can be redeemed once.
+func ConsumeOIDCLoginDB(ctx context.Context, stateHash string) (nonce, codeVerifier string, err error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return "", "", utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", utils.ErrorHandler(err, "Error starting transaction.")
+	}
+
+	var expiresAt time.Time
+	err = tx.QueryRow("SELECT nonce, code_verifier, expires_at FROM oidc_logins WHERE state_hash = ? FOR UPDATE", stateHash).Scan(&nonce, &codeVerifier, &expiresAt)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return "", "", utils.ValidationError(err, "Invalid or expired login state.")
+	} else if err != nil {
+		tx.Rollback()
+		return "", "", utils.ErrorHandler(err, "Database query error.")
+	}
+
+	_, err = tx.Exec("DELETE FROM oidc_logins WHERE state_hash = ?", stateHash)
+	if err != nil {
+		tx.Rollback()
+		return "", "", utils.ErrorHandler(err, "Error consuming login state.")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", "", utils.ErrorHandler(err, "Could not commit changes.")
+	}
+
+	if time.Now().After(expiresAt) {
+		return "", "", utils.ValidationError(nil, "Invalid or expired login state.")
+	}
+	return nonce, codeVerifier, nil
+}
@@ -0,0 +1,92 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+func AddSessionDB(ctx context.Context, tokenHash string, session models.Session) (models.Session, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.Session{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	now := time.Now().UTC()
+	resp, err := db.Exec("INSERT INTO sessions (token_hash, account_id, csrf_token, last_seen_at, expires_at) VALUES (?,?,?,?,?)",
+		tokenHash, session.AccountID, session.CSRFToken, now, session.ExpiresAt.UTC())
+	if err != nil {
+		return models.Session{}, utils.ErrorHandler(err, "Error storing session.")
+	}
+
+	newId, err := resp.LastInsertId()
+	if err != nil {
+		return models.Session{}, utils.ErrorHandler(err, "Error getting newly created ID.")
+	}
+	session.ID = int(newId)
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	return session, nil
+}
+
+// GetSessionDB returns the session of a cookie value hash. Revoked sessions
+// are not found; the caller checks the timeouts.
+func GetSessionDB(ctx context.Context, tokenHash string) (models.Session, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.Session{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var session models.Session
+	err = db.QueryRow("SELECT id, account_id, csrf_token, created_at, last_seen_at, expires_at FROM sessions WHERE token_hash = ? AND revoked_at IS NULL", tokenHash).
+		Scan(&session.ID, &session.AccountID, &session.CSRFToken, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return models.Session{}, utils.NotFoundError(err, "Session not found.")
+	} else if err != nil {
+		return models.Session{}, utils.ErrorHandler(err, "Database query error.")
+	}
+	return session, nil
+}
+
+func TouchSessionDB(ctx context.Context, id int) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("UPDATE sessions SET last_seen_at = UTC_TIMESTAMP() WHERE id = ?", id)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error updating session.")
+	}
+	return nil
+}
+
+func RevokeSessionDB(ctx context.Context, id int) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("UPDATE sessions SET revoked_at = UTC_TIMESTAMP() WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error revoking session.")
+	}
+	return nil
+}
+
+// RevokeSessionByTokenDB ends the session of a cookie value hash, if any.
+func RevokeSessionByTokenDB(ctx context.Context, tokenHash string) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("UPDATE sessions SET revoked_at = UTC_TIMESTAMP() WHERE token_hash = ? AND revoked_at IS NULL", tokenHash)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error revoking session.")
+	}
+	return nil
+}
@@ -1,29 +1,77 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// pool is the connection pool of one database. ready is closed once it was
+// opened and pinged, or that failed.
+type pool struct {
+	ready chan struct{}
+	db    *sql.DB
+	err   error
+}
+
+// pools holds one connection pool per database, i.e. per tenant.
+var (
+	poolsMu sync.Mutex
+	pools   = map[string]*pool{}
+
+	loadEnvOnce sync.Once
+)
+
+// ConnectToDB returns the connection pool of database dbName, opening it on
+// first use. An empty dbName selects the control database DB_NAME, which
+// also serves requests that name no tenant. Pools are shared, callers must
+// not close them. A database that is slow to answer only holds up the
+// requests for it, and one that failed to open is tried again next time.
 func ConnectToDB(dbName string) (*sql.DB, error) {
-	utils.LoadEnv()
+	loadEnvOnce.Do(utils.LoadEnv)
+
+	if dbName == "" {
+		dbName = os.Getenv("DB_NAME")
+	}
+
+	poolsMu.Lock()
+	p, ok := pools[dbName]
+	if ok {
+		poolsMu.Unlock()
+		<-p.ready
+		return p.db, p.err
+	}
+	p = &pool{ready: make(chan struct{})}
+	pools[dbName] = p
+	poolsMu.Unlock()
+
+	p.db, p.err = openDB(dbName)
+	if p.err != nil {
+		poolsMu.Lock()
+		delete(pools, dbName)
+		poolsMu.Unlock()
+	}
+	close(p.ready)
+	return p.db, p.err
+}
 
+func openDB(dbName string) (*sql.DB, error) {
 	host := os.Getenv("DB_HOST")
 	port := os.Getenv("DB_PORT")
 	user := os.Getenv("DB_USER")
 	pass := os.Getenv("DB_PASS")
-	name := os.Getenv("DB_NAME")
 
 	// Build DSN string
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-		user, pass, host, port, name)
+		user, pass, host, port, dbName)
 
 	db, err := sql.Open("mysql", dsn)
 
@@ -48,9 +96,52 @@ func ConnectToDB(dbName string) (*sql.DB, error) {
 
 	// Actual connection test
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping DB: %w", err)
 	}
 
-	log.Println("✅ Successfully connected to MariaDB")
+	log.Printf("✅ Successfully connected to MariaDB database %s", dbName)
 	return db, nil
 }
+
+// TenantDB returns the pool of the tenant the request context belongs to.
+func TenantDB(ctx context.Context) (*sql.DB, error) {
+	if tenant := utils.TenantFrom(ctx); tenant != nil {
+		return ConnectToDB(tenant.Database)
+	}
+	return ConnectToDB("")
+}
+
+// closePool closes the pool of dbName once it finished opening, before the
+// database is dropped.
+func closePool(dbName string) {
+	poolsMu.Lock()
+	p, ok := pools[dbName]
+	delete(pools, dbName)
+	poolsMu.Unlock()
+
+	if ok {
+		<-p.ready
+		if p.db != nil {
+			p.db.Close()
+		}
+	}
+}
+
+// CloseAll closes every pool, for shutdown.
+func CloseAll() {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	for name, p := range pools {
+		select {
+		case <-p.ready:
+			if p.db != nil {
+				p.db.Close()
+			}
+		default:
+			// still connecting, nothing to close yet
+		}
+		delete(pools, name)
+	}
+}
@@ -0,0 +1,90 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+func GetStudentByIdDB(ctx context.Context, idNum int) (models.Student, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.Student{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var student models.Student
+	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", idNum).Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
+	if err == sql.ErrNoRows {
+		return models.Student{}, utils.NotFoundError(err, "Student not found.")
+	} else if err != nil {
+		return models.Student{}, utils.ErrorHandler(err, "Database query error.")
+	}
+	return student, nil
+}
+
+func GetStudentsDB(r *http.Request) ([]models.Student, error) {
+	db, err := TenantDB(r.Context())
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	query := "SELECT id, first_name, last_name, email, class FROM students WHERE 1=1"
+	var args []any
+
+	if class := utils.Params(r).Get("class"); class != "" {
+		query += " AND class = ?"
+		args = append(args, class)
+	}
+
+	// only return the records the caller may read
+	principal := utils.PrincipalFrom(r)
+	switch authz.ScopeOf(principal, authz.StudentsRead) {
+	case authz.ScopeAll:
+	case authz.ScopeClass:
+		query += " AND class = ?"
+		args = append(args, principal.Class)
+	case authz.ScopeOwn:
+		query += " AND id = ?"
+		args = append(args, principal.StudentID)
+	default:
+		return nil, authz.Forbidden(authz.StudentsRead)
+	}
+
+	rows, err := db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+	defer rows.Close()
+
+	students := []models.Student{}
+	for rows.Next() {
+		var student models.Student
+		err := rows.Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "Database scanning db results.")
+		}
+		students = append(students, student)
+	}
+	return students, nil
+}
+
+func PatchStudentDB(ctx context.Context, student models.Student, updates map[string]any) (models.Student, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return models.Student{}, utils.UnavailableError(err, "Error connecting to DB.")
+	}
+
+	if err := applyUpdates(&student, updates); err != nil {
+		return models.Student{}, err
+	}
+
+	_, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", student.FirstName, student.LastName, student.Email, student.Class, student.ID)
+	if err != nil {
+		return models.Student{}, utils.ErrorHandler(err, "Error updating entry.")
+	}
+	return student, nil
+}
@@ -1,23 +1,62 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
 	"reflect"
-	"strconv"
 	"strings"
 
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
 	"github.com/georgiev098/golang-basic-crud-api/internal/models"
 	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
 )
 
+// TeacherRepo is the repository.TeacherRepository of the tenant databases.
+type TeacherRepo struct{}
+
+func (TeacherRepo) GetTeachers(r *http.Request) ([]models.Teacher, error) {
+	return GetTeachersDB(nil, r)
+}
+
+func (TeacherRepo) GetTeacherById(ctx context.Context, id int) (models.Teacher, error) {
+	return GetTeacherByIdDB(ctx, id)
+}
+
+func (TeacherRepo) GetTeacherByEmail(ctx context.Context, email string) (models.Teacher, error) {
+	return GetTeacherByEmailDB(ctx, email)
+}
+
+func (TeacherRepo) AddTeachers(ctx context.Context, teachers []models.Teacher) ([]models.Teacher, error) {
+	return AddTeacherToDB(ctx, teachers)
+}
+
+func (TeacherRepo) UpdateTeacher(ctx context.Context, id int, teacher models.Teacher) (models.Teacher, error) {
+	return UpdateTeacherDB(ctx, id, teacher)
+}
+
+func (TeacherRepo) PatchTeacher(ctx context.Context, id int, updates map[string]any) (models.Teacher, error) {
+	return PatchSingleTeacherDB(ctx, id, updates)
+}
+
+func (TeacherRepo) PatchTeachers(ctx context.Context, updates []map[string]any) error {
+	return PatchMultipleTeachersDB(ctx, updates)
+}
+
+func (TeacherRepo) DeleteTeacher(ctx context.Context, id int) error {
+	return DeleteSingleTeacherDB(ctx, id)
+}
+
+func (TeacherRepo) DeleteTeachers(ctx context.Context, ids []int) ([]int, error) {
+	return DeleteMultipleTeachersDB(ctx, ids)
+}
+
 func GetTeachersDB(teachers []models.Teacher, r *http.Request) ([]models.Teacher, error) {
-	db, err := ConnectToDB("school")
+	db, err := TenantDB(r.Context())
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Could not establish DB connection.")
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
 	}
-	defer db.Close()
 
 	query := "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE 1=1"
 
@@ -25,6 +64,17 @@ func GetTeachersDB(teachers []models.Teacher, r *http.Request) ([]models.Teacher
 
 	query, args = AddFilters(r, query, args)
 
+	// only return the records the caller may read
+	principal := utils.PrincipalFrom(r)
+	switch authz.ScopeOf(principal, authz.TeachersRead) {
+	case authz.ScopeAll:
+	case authz.ScopeOwn:
+		query += " AND id = ?"
+		args = append(args, principal.TeacherID)
+	default:
+		return nil, authz.Forbidden(authz.TeachersRead)
+	}
+
 	query = AddSorting(r, query)
 
 	rows, err := db.Query(query, args...)
@@ -49,29 +99,43 @@ func GetTeachersDB(teachers []models.Teacher, r *http.Request) ([]models.Teacher
 	return teachers, nil
 }
 
-func GetTeacherByIdDB(idNum int) (models.Teacher, error) {
-	db, err := ConnectToDB("school")
+func GetTeacherByIdDB(ctx context.Context, idNum int) (models.Teacher, error) {
+	db, err := TenantDB(ctx)
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Could not establish DB connection.")
+		return models.Teacher{}, utils.UnavailableError(err, "Could not establish DB connection.")
 	}
-	defer db.Close()
 
 	var teacher models.Teacher
 	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", idNum).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Class, &teacher.Subject)
 	if err == sql.ErrNoRows {
-		return models.Teacher{}, utils.ErrorHandler(err, "Teacher not found.")
+		return models.Teacher{}, utils.NotFoundError(err, "Teacher not found.")
 	} else if err != nil {
 		return models.Teacher{}, utils.ErrorHandler(err, "Database query error.")
 	}
 	return teacher, nil
 }
 
-func AddTeacherToDB(newTeachers []models.Teacher) ([]models.Teacher, error) {
-	db, err := ConnectToDB("school")
+func GetTeacherByEmailDB(ctx context.Context, email string) (models.Teacher, error) {
+	db, err := TenantDB(ctx)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Could not establish DB connection.")
+		return models.Teacher{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var teacher models.Teacher
+	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE email = ?", email).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Class, &teacher.Subject)
+	if err == sql.ErrNoRows {
+		return models.Teacher{}, utils.NotFoundError(err, "Teacher not found.")
+	} else if err != nil {
+		return models.Teacher{}, utils.ErrorHandler(err, "Database query error.")
+	}
+	return teacher, nil
+}
+
+func AddTeacherToDB(ctx context.Context, newTeachers []models.Teacher) ([]models.Teacher, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
 	}
-	defer db.Close()
 
 	stmt, err := db.Prepare("INSERT INTO teachers (first_name, last_name, email, class, subject) VALUES (?,?,?,?,?)")
 	if err != nil {
@@ -103,19 +167,17 @@ func AddTeacherToDB(newTeachers []models.Teacher) ([]models.Teacher, error) {
 	return addedTeachers, nil
 }
 
-func UpdateTeacherDB(id int, updatedTeacher models.Teacher) (models.Teacher, error) {
-	db, err := ConnectToDB("teachers")
+func UpdateTeacherDB(ctx context.Context, id int, updatedTeacher models.Teacher) (models.Teacher, error) {
+	db, err := TenantDB(ctx)
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error connecting to DB.")
+		return models.Teacher{}, utils.UnavailableError(err, "Error connecting to DB.")
 	}
 
-	defer db.Close()
-
 	var existingTeacher models.Teacher
 
-	err = db.QueryRow("SELECT id, first_name, last_name, email, subject, class FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.Class, &existingTeacher.Email, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Subject)
+	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
 	if err == sql.ErrNoRows {
-		return models.Teacher{}, utils.ErrorHandler(err, "Teacher not found.")
+		return models.Teacher{}, utils.NotFoundError(err, "Teacher not found.")
 	} else {
 		if err != nil {
 			return models.Teacher{}, utils.ErrorHandler(err, "Retrieving teacher from DB.")
@@ -131,74 +193,69 @@ func UpdateTeacherDB(id int, updatedTeacher models.Teacher) (models.Teacher, err
 	return updatedTeacher, nil
 }
 
-func PatchMultipleTeachersDB(updates []map[string]any) error {
-	db, err := ConnectToDB("teachers")
-	if err != nil {
-		return utils.ErrorHandler(err, "Error connecting to DB.")
+// applyUpdates sets the fields of the struct record points to that the json
+// tags in updates name, leaving out id.
+func applyUpdates(record any, updates map[string]any) error {
+	recordVal := reflect.ValueOf(record).Elem()
+	recordType := recordVal.Type()
+
+	for k, v := range updates {
+		if k == "id" {
+			continue
+		}
+		for i := 0; i < recordVal.NumField(); i++ {
+			name, _, _ := strings.Cut(recordType.Field(i).Tag.Get("json"), ",")
+			if name != k {
+				continue
+			}
+
+			fieldVal := recordVal.Field(i)
+			val := reflect.ValueOf(v)
+			if !val.IsValid() || val.Kind() != fieldVal.Kind() {
+				return utils.ValidationError(nil, fmt.Sprintf("Cannot use %v as %s.", v, k))
+			}
+			fieldVal.Set(val.Convert(fieldVal.Type()))
+			break
+		}
 	}
+	return nil
+}
 
-	defer db.Close()
+func PatchMultipleTeachersDB(ctx context.Context, updates []map[string]any) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Error connecting to DB.")
+	}
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return utils.ErrorHandler(err, "Error starting transaction.")
 	}
 
 	for _, update := range updates {
-		idStr, ok := update["id"].(string)
+		id, ok := utils.JSONID(update["id"])
 		if !ok {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Invalid integer.")
-		}
-
-		id, err := strconv.Atoi(idStr)
-
-		if err != nil {
-			tx.Rollback()
-			if err == sql.ErrNoRows {
-				return utils.ErrorHandler(err, "Error converting ID to Int.")
-			}
+			return utils.ValidationError(nil, "Invalid teacher ID.")
 		}
 
 		var teacherFromDb models.Teacher
-		err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ? ", id).Scan(&teacherFromDb.ID, &teacherFromDb.Class, &teacherFromDb.Email, &teacherFromDb.FirstName, &teacherFromDb.LastName, &teacherFromDb.Subject)
+		err = tx.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ? FOR UPDATE", id).Scan(&teacherFromDb.ID, &teacherFromDb.FirstName, &teacherFromDb.LastName, &teacherFromDb.Email, &teacherFromDb.Class, &teacherFromDb.Subject)
 		if err != nil {
 			tx.Rollback()
 			if err == sql.ErrNoRows {
-				return utils.ErrorHandler(err, "Teacher not found.")
+				return utils.NotFoundError(err, "Teacher not found.")
 			} else {
 				return utils.ErrorHandler(err, "Could not query row.")
 			}
 		}
 
-		teacherVal := reflect.ValueOf(&teacherFromDb).Elem()
-		teacherType := teacherVal.Type()
-
-		for k, v := range update {
-			if k == "id" {
-				continue
-			}
-			for i := 0; i < teacherVal.NumField(); i++ {
-				field := teacherType.Field(i)
-
-				if field.Tag.Get("json") == k+" ,omitempty" {
-					fieldVal := teacherVal.Field(i)
-					if fieldVal.CanSet() {
-						val := reflect.ValueOf(v)
-						if val.Type().ConvertibleTo(fieldVal.Type()) {
-							fieldVal.Set(val.Convert(fieldVal.Type()))
-						} else {
-							tx.Rollback()
-							return utils.ErrorHandler(err, fmt.Sprintf("Cannot convert %v to %v", val.Type(), fieldVal.Type()))
-						}
-					}
-					break
-				}
-
-			}
+		if err := applyUpdates(&teacherFromDb, update); err != nil {
+			tx.Rollback()
+			return err
 		}
 
-		_, err = tx.Exec("UPDATE teachers SET first_name = ?, last_name = ?, class = ?, email = ?, subject = ? WHERE id = ?", teacherFromDb.FirstName, teacherFromDb.LastName, teacherFromDb.Class, teacherFromDb.Email, teacherFromDb.Subject)
+		_, err = tx.Exec("UPDATE teachers SET first_name = ?, last_name = ?, class = ?, email = ?, subject = ? WHERE id = ?", teacherFromDb.FirstName, teacherFromDb.LastName, teacherFromDb.Class, teacherFromDb.Email, teacherFromDb.Subject, teacherFromDb.ID)
 		if err != nil {
 			tx.Rollback()
 			return utils.ErrorHandler(err, "Error updating teacher.")
@@ -213,40 +270,25 @@ func PatchMultipleTeachersDB(updates []map[string]any) error {
 	return nil
 }
 
-func PatchSingleTeacherDB(id int, updates map[string]any) (models.Teacher, error) {
-	db, err := ConnectToDB("teachers")
+func PatchSingleTeacherDB(ctx context.Context, id int, updates map[string]any) (models.Teacher, error) {
+	db, err := TenantDB(ctx)
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error connecting to DB.")
+		return models.Teacher{}, utils.UnavailableError(err, "Error connecting to DB.")
 	}
 
-	defer db.Close()
-
 	var existingTeacher models.Teacher
 
-	err = db.QueryRow("SELECT id, first_name, last_name, email, subject, class FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.Class, &existingTeacher.Email, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Subject)
+	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
 	if err == sql.ErrNoRows {
-		return models.Teacher{}, utils.ErrorHandler(err, "Teacher not found.")
+		return models.Teacher{}, utils.NotFoundError(err, "Teacher not found.")
 	} else {
 		if err != nil {
 			return models.Teacher{}, utils.ErrorHandler(err, "Retrieving teacher from DB.")
 		}
 	}
 
-	teacherVal := reflect.ValueOf(existingTeacher).Elem()
-	teacherType := teacherVal.Type()
-
-	for k, v := range updates {
-		for i := 0; i < teacherVal.NumField(); i++ {
-			field := teacherType.Field(i)
-			field.Tag.Get("json")
-
-			if field.Tag.Get("json") == k+" ,omitempty" {
-				if teacherVal.Field(i).CanSet() {
-					teacherVal.Field(i).Set(reflect.ValueOf(v).Convert(teacherVal.Field(i).Type()))
-				}
-			}
-
-		}
+	if err := applyUpdates(&existingTeacher, updates); err != nil {
+		return models.Teacher{}, err
 	}
 
 	_, err = db.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", existingTeacher.FirstName, existingTeacher.LastName, existingTeacher.Email, existingTeacher.Class, existingTeacher.Subject, existingTeacher.ID)
@@ -256,14 +298,12 @@ func PatchSingleTeacherDB(id int, updates map[string]any) (models.Teacher, error
 	return existingTeacher, nil
 }
 
-func DeleteSingleTeacherDB(id int) error {
-	db, err := ConnectToDB("teachers")
+func DeleteSingleTeacherDB(ctx context.Context, id int) error {
+	db, err := TenantDB(ctx)
 	if err != nil {
-		return utils.ErrorHandler(err, "Error connecting to DB")
+		return utils.UnavailableError(err, "Error connecting to DB")
 	}
 
-	defer db.Close()
-
 	result, err := db.Exec("DELETE FROM teachers WHERE id = ? ", id)
 	if err != nil {
 		return utils.ErrorHandler(err, "Could not delete teacher.")
@@ -275,17 +315,16 @@ func DeleteSingleTeacherDB(id int) error {
 	}
 
 	if rowsAffected == 0 {
-		return utils.ErrorHandler(err, "Teacher not found.")
+		return utils.NotFoundError(err, "Teacher not found.")
 	}
 	return nil
 }
 
-func DeleteMultipleTeachersDB(ids []int) ([]int, error) {
-	db, err := ConnectToDB("teachers")
+func DeleteMultipleTeachersDB(ctx context.Context, ids []int) ([]int, error) {
+	db, err := TenantDB(ctx)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error connecting to DB.")
+		return nil, utils.UnavailableError(err, "Error connecting to DB.")
 	}
-	defer db.Close()
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, utils.ErrorHandler(err, "Error starting transaction to DB.")
@@ -319,7 +358,7 @@ func DeleteMultipleTeachersDB(ids []int) ([]int, error) {
 
 		if rowsAffected < 1 {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, fmt.Sprintf("ID %d does not exists", id))
+			return nil, utils.NotFoundError(err, fmt.Sprintf("ID %d does not exist", id))
 		}
 
 	}
@@ -330,7 +369,7 @@ func DeleteMultipleTeachersDB(ids []int) ([]int, error) {
 	}
 
 	if len(deletedIds) < 1 {
-		return nil, utils.ErrorHandler(err, "Ids do not exist.")
+		return nil, utils.NotFoundError(err, "Ids do not exist.")
 	}
 	return deletedIds, nil
 }
@@ -345,7 +384,7 @@ func AddFilters(r *http.Request, query string, args []any) (string, []any) {
 	}
 
 	for param, dbField := range params {
-		value := r.URL.Query().Get(param)
+		value := utils.Params(r).Get(param)
 		if value != "" {
 			query += " AND " + dbField + " = ?"
 			args = append(args, value)
@@ -355,27 +394,19 @@ func AddFilters(r *http.Request, query string, args []any) (string, []any) {
 	return query, args
 }
 
+// AddSorting appends the valid sort-by clauses, if any; invalid ones are
+// skipped.
 func AddSorting(r *http.Request, query string) string {
-	sortParams := r.URL.Query()["sort-by"]
-	if len(sortParams) > 0 {
-		query += " ORDER BY "
-		for i, param := range sortParams {
-			parts := strings.Split(param, ":")
-			if len(parts) != 2 {
-				continue
-			}
-
-			field, order := parts[0], parts[1]
-
-			if !IsValidSortField(field) || !IsValidSortOrder(order) {
-				continue
-			}
-			if i > 0 {
-				query += ","
-			}
-
-			query += " " + field + " " + order
+	var clauses []string
+	for _, param := range utils.Params(r)["sort-by"] {
+		field, order, ok := strings.Cut(param, ":")
+		if !ok || !IsValidSortField(field) || !IsValidSortOrder(order) {
+			continue
 		}
+		clauses = append(clauses, field+" "+order)
+	}
+	if len(clauses) > 0 {
+		query += " ORDER BY " + strings.Join(clauses, ", ")
 	}
 	return query
 }
@@ -0,0 +1,170 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/migrations"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+const tenantColumns = "id, slug, name, db_name, created_at"
+
+// Tenants are always read from the control database, whatever tenant ctx
+// belongs to.
+
+func GetTenantBySlugDB(ctx context.Context, slug string) (models.Tenant, error) {
+	db, err := ConnectToDB("")
+	if err != nil {
+		return models.Tenant{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var tenant models.Tenant
+	err = db.QueryRow("SELECT "+tenantColumns+" FROM tenants WHERE slug = ?", slug).Scan(&tenant.ID, &tenant.Slug, &tenant.Name, &tenant.DBName, &tenant.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.Tenant{}, utils.NotFoundError(err, "Tenant not found.")
+	} else if err != nil {
+		return models.Tenant{}, utils.ErrorHandler(err, "Database query error.")
+	}
+	return tenant, nil
+}
+
+func GetTenantsDB(ctx context.Context) ([]models.Tenant, error) {
+	db, err := ConnectToDB("")
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	rows, err := db.Query("SELECT " + tenantColumns + " FROM tenants ORDER BY slug")
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+	defer rows.Close()
+
+	tenants := []models.Tenant{}
+	for rows.Next() {
+		var tenant models.Tenant
+		err := rows.Scan(&tenant.ID, &tenant.Slug, &tenant.Name, &tenant.DBName, &tenant.CreatedAt)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "Error scanning tenant.")
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// AddTenantDB provisions a tenant: it creates the database school_<slug>,
+// applies every migration to it, adds exec, the first account, unless nil
+// and registers the tenant. When a step fails the database is dropped
+// again, so provisioning the slug can simply be retried. DB_USER needs the
+// CREATE and DROP privileges on school_% databases.
+func AddTenantDB(ctx context.Context, tenant models.Tenant, exec *models.Account) (models.Tenant, error) {
+	db, err := ConnectToDB("")
+	if err != nil {
+		return models.Tenant{}, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var exists int
+	err = db.QueryRow("SELECT COUNT(*) FROM tenants WHERE slug = ?", tenant.Slug).Scan(&exists)
+	if err != nil {
+		return models.Tenant{}, utils.ErrorHandler(err, "Database query error.")
+	}
+	if exists > 0 {
+		return models.Tenant{}, utils.ConflictError(nil, "A tenant with this slug already exists.")
+	}
+
+	// slugs are validated to [a-z0-9-], which keeps the name safe to quote
+	tenant.DBName = "school_" + strings.ReplaceAll(tenant.Slug, "-", "_")
+
+	// fails when a concurrent request for the slug got here first, whose
+	// database must not be dropped
+	_, err = db.Exec("CREATE DATABASE `" + tenant.DBName + "` CHARACTER SET utf8mb4")
+	if err != nil {
+		return models.Tenant{}, utils.ErrorHandler(err, "Error creating tenant database.")
+	}
+
+	err = provisionTenantDB(ctx, db, tenant, exec)
+	if err != nil {
+		closePool(tenant.DBName)
+		if _, dropErr := db.Exec("DROP DATABASE IF EXISTS `" + tenant.DBName + "`"); dropErr != nil {
+			log.Printf("dropping database %s of failed tenant %s: %v", tenant.DBName, tenant.Slug, dropErr)
+		}
+		return models.Tenant{}, err
+	}
+	return GetTenantBySlugDB(ctx, tenant.Slug)
+}
+
+// provisionTenantDB fills the freshly created database of tenant and
+// registers it in the control database db.
+func provisionTenantDB(ctx context.Context, db *sql.DB, tenant models.Tenant, exec *models.Account) error {
+	tenantDB, err := ConnectToDB(tenant.DBName)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+	if err := migrate(tenantDB); err != nil {
+		return utils.ErrorHandler(err, "Error migrating tenant database.")
+	}
+
+	if exec != nil {
+		_, err = AddAccountDB(utils.WithTenant(ctx, &utils.Tenant{Slug: tenant.Slug, Database: tenant.DBName}), *exec)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec("INSERT INTO tenants (slug, name, db_name) VALUES (?,?,?)", tenant.Slug, tenant.Name, tenant.DBName)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error inserting tenant into DB.")
+	}
+	return nil
+}
+
+// migrate applies the embedded migrations to a tenant database, skipping
+// those of the control database.
+func migrate(db *sql.DB) error {
+	files, err := fs.Glob(migrations.FS, "*.sql")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		content, err := fs.ReadFile(migrations.FS, name)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(string(content), "-- control:") {
+			continue
+		}
+
+		for _, stmt := range splitStatements(string(content)) {
+			if _, err := db.Exec(stmt); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// splitStatements drops comment lines and splits a migration on semicolons,
+// the driver runs one statement per call.
+func splitStatements(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
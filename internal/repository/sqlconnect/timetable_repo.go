@@ -0,0 +1,233 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+const timetableColumns = "id, teacher_id, class, course, weekday, period, TIME_FORMAT(start_time, '%H:%i'), TIME_FORMAT(end_time, '%H:%i'), room"
+
+func GetTimetableDB(r *http.Request) ([]models.TimetableSlot, error) {
+	query := "SELECT " + timetableColumns + " FROM timetable_slots WHERE 1=1"
+
+	var args []any
+	params := map[string]string{
+		"teacher_id": "teacher_id",
+		"class":      "class",
+		"room":       "room",
+		"weekday":    "weekday",
+	}
+	for param, dbField := range params {
+		value := utils.Params(r).Get(param)
+		if value != "" {
+			query += " AND " + dbField + " = ?"
+			args = append(args, value)
+		}
+	}
+
+	// only return the records the caller may read
+	principal := utils.PrincipalFrom(r)
+	switch authz.ScopeOf(principal, authz.TimetableRead) {
+	case authz.ScopeAll:
+	case authz.ScopeClass:
+		query += " AND class = ?"
+		args = append(args, principal.Class)
+	default:
+		return nil, authz.Forbidden(authz.TimetableRead)
+	}
+
+	return queryTimetable(r.Context(), query, args...)
+}
+
+func GetTeacherTimetableDB(ctx context.Context, teacherId int) ([]models.TimetableSlot, error) {
+	_, err := GetTeacherByIdDB(ctx, teacherId)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryTimetable(ctx, "SELECT "+timetableColumns+" FROM timetable_slots WHERE teacher_id = ?", teacherId)
+}
+
+func GetClassTimetableDB(ctx context.Context, class string) ([]models.TimetableSlot, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	// there is no classes table, a class exists while anyone is in it
+	var exists bool
+	err = db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM teachers WHERE class = ?) OR EXISTS(SELECT 1 FROM students WHERE class = ?) OR EXISTS(SELECT 1 FROM timetable_slots WHERE class = ?)",
+		class, class, class,
+	).Scan(&exists)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+	if !exists {
+		return nil, utils.NotFoundError(sql.ErrNoRows, "Class not found.")
+	}
+
+	return queryTimetable(ctx, "SELECT "+timetableColumns+" FROM timetable_slots WHERE class = ?", class)
+}
+
+func queryTimetable(ctx context.Context, query string, args ...any) ([]models.TimetableSlot, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	rows, err := db.Query(query+" ORDER BY weekday, start_time", args...)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+	defer rows.Close()
+
+	slots := []models.TimetableSlot{}
+	for rows.Next() {
+		var slot models.TimetableSlot
+		err := rows.Scan(&slot.ID, &slot.TeacherID, &slot.Class, &slot.Course, &slot.Weekday, &slot.Period, &slot.StartTime, &slot.EndTime, &slot.Room)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "Database scanning db results.")
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// AddTimetableSlotsDB books slots unless they overlap a slot of the same
+// teacher, class or room. The check and the insert run under the locks of
+// the weekday rows, taken in ascending order, so concurrent bookings of a
+// day serialize. Locking the overlapping slots would not: when there are
+// none, FOR UPDATE takes gap locks only, and two such inserts deadlock.
+func AddTimetableSlotsDB(ctx context.Context, newSlots []models.TimetableSlot) ([]models.TimetableSlot, error) {
+	weekdays := map[int]bool{}
+	for i, slot := range newSlots {
+		if err := checkTimetableSlot(slot); err != nil {
+			return nil, utils.ValidationError(err, fmt.Sprintf("Invalid timetable slot %d: %v", i, err))
+		}
+		weekdays[slot.Weekday] = true
+	}
+
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Error starting transaction.")
+	}
+
+	for _, weekday := range slices.Sorted(maps.Keys(weekdays)) {
+		err = tx.QueryRow("SELECT weekday FROM timetable_weekdays WHERE weekday = ? FOR UPDATE", weekday).Scan(&weekday)
+		if err != nil {
+			tx.Rollback()
+			return nil, utils.ErrorHandler(err, "Error locking the timetable.")
+		}
+	}
+
+	addedSlots := make([]models.TimetableSlot, len(newSlots))
+	for i, slot := range newSlots {
+		// a plain read, it sees every booking committed before the weekday
+		// locks were granted and those made earlier in this batch
+		var existing models.TimetableSlot
+		err = tx.QueryRow(
+			"SELECT id, teacher_id, class, room FROM timetable_slots WHERE weekday = ? AND start_time < ? AND end_time > ? AND (teacher_id = ? OR class = ? OR room = ?) LIMIT 1",
+			slot.Weekday, slot.EndTime, slot.StartTime, slot.TeacherID, slot.Class, slot.Room,
+		).Scan(&existing.ID, &existing.TeacherID, &existing.Class, &existing.Room)
+		if err == nil {
+			tx.Rollback()
+			return nil, utils.ConflictError(err, fmt.Sprintf("Timetable slot %d conflicts with slot %d: %s", i, existing.ID, conflictReason(slot, existing)))
+		} else if err != sql.ErrNoRows {
+			tx.Rollback()
+			return nil, utils.ErrorHandler(err, "Error checking timetable conflicts.")
+		}
+
+		resp, err := tx.Exec(
+			"INSERT INTO timetable_slots (teacher_id, class, course, weekday, period, start_time, end_time, room) VALUES (?,?,?,?,?,?,?,?)",
+			slot.TeacherID, slot.Class, slot.Course, slot.Weekday, slot.Period, slot.StartTime, slot.EndTime, slot.Room,
+		)
+		if err != nil {
+			tx.Rollback()
+			return nil, utils.ErrorHandler(err, "Error inserting data into DB.")
+		}
+
+		newId, err := resp.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return nil, utils.ErrorHandler(err, "Error getting newly created ID.")
+		}
+		slot.ID = int(newId)
+		addedSlots[i] = slot
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Could not commit changes.")
+	}
+	return addedSlots, nil
+}
+
+func DeleteTimetableSlotDB(ctx context.Context, id int) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Error connecting to DB")
+	}
+
+	result, err := db.Exec("DELETE FROM timetable_slots WHERE id = ?", id)
+	if err != nil {
+		return utils.ErrorHandler(err, "Could not delete timetable slot.")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error retrieving delete result.")
+	}
+
+	if rowsAffected == 0 {
+		return utils.NotFoundError(err, "Timetable slot not found.")
+	}
+	return nil
+}
+
+func checkTimetableSlot(slot models.TimetableSlot) error {
+	if slot.TeacherID == 0 || slot.Class == "" || slot.Course == "" || slot.Room == "" {
+		return fmt.Errorf("teacher_id, class, course and room are required")
+	}
+	if slot.Weekday < 1 || slot.Weekday > 7 {
+		return fmt.Errorf("weekday must be between 1 (Monday) and 7 (Sunday)")
+	}
+
+	start, err := time.Parse("15:04", slot.StartTime)
+	if err != nil {
+		return fmt.Errorf("start_time must be HH:MM")
+	}
+	end, err := time.Parse("15:04", slot.EndTime)
+	if err != nil {
+		return fmt.Errorf("end_time must be HH:MM")
+	}
+	if !start.Before(end) {
+		return fmt.Errorf("start_time must be before end_time")
+	}
+	return nil
+}
+
+func conflictReason(slot, existing models.TimetableSlot) string {
+	switch {
+	case slot.TeacherID == existing.TeacherID:
+		return fmt.Sprintf("teacher %d is already booked", slot.TeacherID)
+	case slot.Class == existing.Class:
+		return fmt.Sprintf("class %s is already booked", slot.Class)
+	default:
+		return fmt.Sprintf("room %s is already booked", slot.Room)
+	}
+}
@@ -0,0 +1,233 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/georgiev098/golang-basic-crud-api/internal/models"
+	"github.com/georgiev098/golang-basic-crud-api/pkg/utils"
+)
+
+// GetTOTPSecretDB returns the sealed TOTP secret of an account, which is
+// empty when no enrollment was started.
+func GetTOTPSecretDB(ctx context.Context, accountId int) (string, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return "", utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var secret sql.NullString
+	err = db.QueryRow("SELECT totp_secret FROM accounts WHERE id = ?", accountId).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", utils.NotFoundError(err, "Account not found.")
+	} else if err != nil {
+		return "", utils.ErrorHandler(err, "Database query error.")
+	}
+	return secret.String, nil
+}
+
+// StartTOTPEnrollmentDB stores a new secret that only becomes active once
+// EnableTOTPDB confirms the first code.
+func StartTOTPEnrollmentDB(ctx context.Context, accountId int, sealedSecret string) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	result, err := db.Exec("UPDATE accounts SET totp_secret = ?, totp_last_step = NULL WHERE id = ? AND totp_enabled_at IS NULL", sealedSecret, accountId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing TOTP secret.")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error retrieving update result.")
+	}
+	if rowsAffected == 0 {
+		return utils.ConflictError(nil, "Two-factor authentication is already enabled.")
+	}
+	return nil
+}
+
+// ResealTOTPSecretDB replaces a secret sealed under a previous encryption key
+// with the same secret sealed under the current one, unless it changed since.
+func ResealTOTPSecretDB(ctx context.Context, accountId int, staleSecret, sealedSecret string) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("UPDATE accounts SET totp_secret = ? WHERE id = ? AND totp_secret = ?", sealedSecret, accountId, staleSecret)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing TOTP secret.")
+	}
+	return nil
+}
+
+// EnableTOTPDB activates two-factor authentication and replaces the recovery
+// codes of the account.
+func EnableTOTPDB(ctx context.Context, accountId int, step int64, recoveryCodeHashes []string) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error starting transaction.")
+	}
+
+	_, err = tx.Exec("UPDATE accounts SET totp_enabled_at = UTC_TIMESTAMP(), totp_last_step = ? WHERE id = ?", step, accountId)
+	if err != nil {
+		tx.Rollback()
+		return utils.ErrorHandler(err, "Error enabling two-factor authentication.")
+	}
+
+	err = replaceRecoveryCodes(tx, accountId, recoveryCodeHashes)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "Could not commit changes.")
+	}
+	return nil
+}
+
+func DisableTOTPDB(ctx context.Context, accountId int) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return utils.ErrorHandler(err, "Error starting transaction.")
+	}
+
+	_, err = tx.Exec("UPDATE accounts SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?", accountId)
+	if err != nil {
+		tx.Rollback()
+		return utils.ErrorHandler(err, "Error disabling two-factor authentication.")
+	}
+
+	err = replaceRecoveryCodes(tx, accountId, nil)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "Could not commit changes.")
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, accountId int, codeHashes []string) error {
+	_, err := tx.Exec("DELETE FROM recovery_codes WHERE account_id = ?", accountId)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error deleting recovery codes.")
+	}
+
+	for _, hash := range codeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (account_id, code_hash) VALUES (?,?)", accountId, hash)
+		if err != nil {
+			return utils.ErrorHandler(err, "Error storing recovery codes.")
+		}
+	}
+	return nil
+}
+
+// UseTOTPStepDB records step as used. It reports false when the step, or a
+// later one, was used before, i.e. the code is being replayed.
+func UseTOTPStepDB(ctx context.Context, accountId int, step int64) (bool, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return false, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	result, err := db.Exec("UPDATE accounts SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)", step, accountId, step)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error updating TOTP state.")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error retrieving update result.")
+	}
+	return rowsAffected > 0, nil
+}
+
+// UseRecoveryCodeDB consumes a recovery code and reports whether it was valid.
+func UseRecoveryCodeDB(ctx context.Context, accountId int, codeHash string) (bool, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return false, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	result, err := db.Exec("UPDATE recovery_codes SET used_at = UTC_TIMESTAMP() WHERE account_id = ? AND code_hash = ? AND used_at IS NULL LIMIT 1", accountId, codeHash)
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error using recovery code.")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, utils.ErrorHandler(err, "Error retrieving update result.")
+	}
+	return rowsAffected > 0, nil
+}
+
+func GetSecurityPoliciesDB(ctx context.Context) ([]models.SecurityPolicy, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return nil, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	rows, err := db.Query("SELECT role, require_2fa FROM security_policies ORDER BY role")
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error.")
+	}
+	defer rows.Close()
+
+	policies := []models.SecurityPolicy{}
+	for rows.Next() {
+		var policy models.SecurityPolicy
+		if err := rows.Scan(&policy.Role, &policy.Require2FA); err != nil {
+			return nil, utils.ErrorHandler(err, "Database scanning db results.")
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// IsTwoFactorRequiredDB reports whether the security policy of role demands
+// two-factor authentication. Roles without a policy do not.
+func IsTwoFactorRequiredDB(ctx context.Context, role string) (bool, error) {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return false, utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	var required bool
+	err = db.QueryRow("SELECT require_2fa FROM security_policies WHERE role = ?", role).Scan(&required)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, utils.ErrorHandler(err, "Database query error.")
+	}
+	return required, nil
+}
+
+func SetSecurityPolicyDB(ctx context.Context, policy models.SecurityPolicy) error {
+	db, err := TenantDB(ctx)
+	if err != nil {
+		return utils.UnavailableError(err, "Could not establish DB connection.")
+	}
+
+	_, err = db.Exec("INSERT INTO security_policies (role, require_2fa) VALUES (?,?) ON DUPLICATE KEY UPDATE require_2fa = VALUES(require_2fa)", policy.Role, policy.Require2FA)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error storing security policy.")
+	}
+	return nil
+}
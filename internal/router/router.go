@@ -1,29 +1,92 @@
 package router
 
 import (
+	"expvar"
 	"net/http"
+	"time"
 
+	"github.com/georgiev098/golang-basic-crud-api/internal/authz"
 	"github.com/georgiev098/golang-basic-crud-api/internal/handlers"
+	"github.com/georgiev098/golang-basic-crud-api/internal/middlewares"
 )
 
+// responseCache answers conditional GETs of the cached routes, mutations of
+// any route invalidate it.
+var responseCache = middlewares.NewResponseCache(5 * time.Minute)
+
+// handle registers a route that requires permission.
+func handle(mux *http.ServeMux, pattern, permission string, handler http.HandlerFunc) {
+	mux.Handle(pattern, middlewares.RequirePermission(permission)(responseCache.Invalidate(handler)))
+}
+
+// handleCached registers a GET route that requires permission and supports
+// conditional requests, with cacheControl for the clients.
+func handleCached(mux *http.ServeMux, pattern, permission, cacheControl string, handler http.HandlerFunc) {
+	mux.Handle(pattern, middlewares.RequirePermission(permission)(responseCache.Cached(cacheControl)(handler)))
+}
+
 func Rotuer() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", handlers.RootHandler)
 
-	mux.HandleFunc("GET /teachers/", handlers.GetTeachers)
-	mux.HandleFunc("POST /teachers/", handlers.AddTeacher)
-	mux.HandleFunc("PATCH /teachers/", handlers.PatchTeachers)
-	mux.HandleFunc("DELETE /teachers/{id}", handlers.DeleteTeachers)
+	mux.HandleFunc("POST /auth/login", handlers.Login)
+	mux.HandleFunc("POST /auth/login/2fa", handlers.LoginSecondFactor)
+	mux.HandleFunc("GET /auth/oidc/login", handlers.OIDCLogin)
+	mux.HandleFunc("GET /auth/oidc/callback", handlers.OIDCCallback)
+	mux.HandleFunc("POST /auth/refresh", handlers.Refresh)
+	mux.HandleFunc("POST /auth/logout", handlers.Logout)
+	mux.HandleFunc("GET /auth/session", handlers.GetSession)
+	mux.HandleFunc("POST /auth/password/forgot", handlers.ForgotPassword)
+	mux.HandleFunc("POST /auth/password/reset", handlers.ResetPassword)
+	mux.HandleFunc("POST /auth/email/verify/request", handlers.RequestEmailVerification)
+	mux.HandleFunc("POST /auth/email/verify", handlers.VerifyEmail)
+	mux.HandleFunc("POST /auth/2fa/enroll", handlers.EnrollTwoFactor)
+	mux.HandleFunc("POST /auth/2fa/confirm", handlers.ConfirmTwoFactor)
+	mux.HandleFunc("POST /auth/2fa/disable", handlers.DisableTwoFactor)
+
+	handle(mux, "GET /security-policies", authz.SecurityManage, handlers.GetSecurityPolicies)
+	handle(mux, "PUT /security-policies", authz.SecurityManage, handlers.UpdateSecurityPolicy)
+
+	handle(mux, "GET /accounts/", authz.AccountsRead, handlers.GetAccounts)
+	handle(mux, "POST /accounts/", authz.AccountsWrite, handlers.AddAccount)
+	handle(mux, "POST /accounts/{id}/unlock", authz.AccountsWrite, handlers.UnlockAccount)
+
+	handle(mux, "GET /audit-log", authz.AuditRead, handlers.GetAuditLog)
+
+	handle(mux, "GET /debug/vars", authz.SecurityManage, expvar.Handler().ServeHTTP)
+
+	handle(mux, "GET /tenants", authz.TenantsManage, handlers.GetTenants)
+	handle(mux, "POST /tenants", authz.TenantsManage, handlers.AddTenant)
+
+	handle(mux, "GET /api-keys", authz.ApiKeysManage, handlers.GetApiKeys)
+	handle(mux, "POST /api-keys", authz.ApiKeysManage, handlers.AddApiKey)
+	handle(mux, "DELETE /api-keys/{id}", authz.ApiKeysManage, handlers.RevokeApiKey)
+
+	handleCached(mux, "GET /teachers/", authz.TeachersRead, "private, no-cache", handlers.GetTeachers)
+	handle(mux, "POST /teachers/", authz.TeachersCreate, handlers.AddTeacher)
+	handle(mux, "PATCH /teachers/", authz.TeachersUpdate, handlers.PatchTeachers)
+	handle(mux, "DELETE /teachers/", authz.TeachersDelete, handlers.DeleteTeachers)
+
+	handleCached(mux, "GET /teachers/{id}", authz.TeachersRead, "private, max-age=30, must-revalidate", handlers.GetTeacher)
+	handle(mux, "PUT /teachers/{id}", authz.TeachersUpdate, handlers.UpdateTeacher)
+	handle(mux, "PATCH /teachers/{id}", authz.TeachersUpdate, handlers.PatchTeacher)
+	handle(mux, "DELETE /teachers/{id}", authz.TeachersDelete, handlers.DeleteTeacher)
+	handle(mux, "GET /teachers/{id}/timetable", authz.TimetableRead, handlers.GetTeacherTimetable)
+	handle(mux, "GET /teachers/{id}/timetable.ics", authz.TimetableRead, handlers.GetTeacherTimetableICS)
+
+	handle(mux, "GET /classes/{id}/timetable", authz.TimetableRead, handlers.GetClassTimetable)
+	handle(mux, "GET /classes/{id}/timetable.ics", authz.TimetableRead, handlers.GetClassTimetableICS)
 
-	mux.HandleFunc("GET /teachers/{id}", handlers.GetTeacher)
-	mux.HandleFunc("PUT /teachers/{id}", handlers.UpdateTeacher)
-	mux.HandleFunc("PATCH /teachers/{id}", handlers.PatchTeacher)
-	mux.HandleFunc("DELETE /teachers/{id}", handlers.DeleteTeacher)
+	handle(mux, "GET /timetable/", authz.TimetableRead, handlers.GetTimetable)
+	handle(mux, "POST /timetable/", authz.TimetableWrite, handlers.AddTimetableSlots)
+	handle(mux, "DELETE /timetable/{id}", authz.TimetableWrite, handlers.DeleteTimetableSlot)
 
-	mux.HandleFunc("/students/", handlers.StudentsHandler)
+	handle(mux, "GET /students/", authz.StudentsRead, handlers.GetStudents)
+	handle(mux, "GET /students/{id}", authz.StudentsRead, handlers.GetStudent)
+	handle(mux, "PATCH /students/{id}", authz.StudentsUpdate, handlers.PatchStudent)
 
-	mux.HandleFunc("/execs/", handlers.ExecsHandler)
+	handle(mux, "/execs/", authz.ExecsRead, handlers.ExecsHandler)
 
 	return mux
 }
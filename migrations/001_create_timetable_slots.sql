@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS timetable_slots (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    teacher_id INT NOT NULL,
+    class VARCHAR(255) NOT NULL,
+    course VARCHAR(255) NOT NULL,
+    weekday TINYINT NOT NULL,
+    period TINYINT NOT NULL DEFAULT 0,
+    start_time TIME NOT NULL,
+    end_time TIME NOT NULL,
+    room VARCHAR(255) NOT NULL,
+    INDEX idx_timetable_weekday (weekday, start_time, end_time),
+    INDEX idx_timetable_teacher (teacher_id),
+    INDEX idx_timetable_class (class),
+    CONSTRAINT fk_timetable_teacher FOREIGN KEY (teacher_id) REFERENCES teachers (id) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+-- Teacher and student accounts are linked to the record they may edit.
+ALTER TABLE accounts
+    ADD COLUMN teacher_id INT NULL,
+    ADD COLUMN student_id INT NULL,
+    ADD CONSTRAINT fk_accounts_teacher FOREIGN KEY (teacher_id) REFERENCES teachers (id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_accounts_student FOREIGN KEY (student_id) REFERENCES students (id) ON DELETE CASCADE;
@@ -0,0 +1,14 @@
+ALTER TABLE accounts ADD COLUMN email_verified_at DATETIME NULL;
+
+-- Single-use tokens for password resets and email verification.
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    account_id INT NOT NULL,
+    purpose VARCHAR(30) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_account_tokens_account (account_id, purpose, created_at),
+    CONSTRAINT fk_account_tokens_account FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+);
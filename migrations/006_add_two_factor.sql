@@ -0,0 +1,20 @@
+-- totp_secret is sealed with AES-GCM by the application, totp_last_step
+-- stops a code from being replayed within its validity window.
+ALTER TABLE accounts
+    ADD COLUMN totp_secret VARCHAR(255) NULL,
+    ADD COLUMN totp_enabled_at DATETIME NULL,
+    ADD COLUMN totp_last_step BIGINT NULL;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    account_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at DATETIME NULL,
+    INDEX idx_recovery_codes_account (account_id),
+    CONSTRAINT fk_recovery_codes_account FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS security_policies (
+    role VARCHAR(20) PRIMARY KEY,
+    require_2fa BOOLEAN NOT NULL DEFAULT FALSE
+);
@@ -0,0 +1,20 @@
+-- Failed logins per subject, which is either "user:<username>" or
+-- "ip:<address>". Rows are reset once the subject stays quiet for a while.
+CREATE TABLE IF NOT EXISTS login_failures (
+    subject VARCHAR(150) PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at DATETIME NOT NULL,
+    locked_until DATETIME NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    event VARCHAR(50) NOT NULL,
+    account_id INT NULL,
+    actor_account_id INT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    detail VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_audit_log_event (event, created_at),
+    INDEX idx_audit_log_account (account_id, created_at)
+);
@@ -0,0 +1,10 @@
+-- control: Tenants live in the control database (DB_NAME) only and are
+-- skipped when a tenant database is provisioned. Every tenant has its own
+-- database, db_name, holding the schema of all other migrations.
+CREATE TABLE IF NOT EXISTS tenants (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    slug VARCHAR(63) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    db_name VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
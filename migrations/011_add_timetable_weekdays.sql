@@ -0,0 +1,7 @@
+-- One row per weekday. Bookings of timetable slots lock the rows of their
+-- weekdays, so concurrent bookings of the same day serialize on them; see
+-- AddTimetableSlotsDB.
+CREATE TABLE IF NOT EXISTS timetable_weekdays (
+    weekday TINYINT PRIMARY KEY
+);
+INSERT IGNORE INTO timetable_weekdays (weekday) VALUES (1), (2), (3), (4), (5), (6), (7);
@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migrations so new tenant databases can
+// be provisioned by the running server.
+package migrations
+
+import "embed"
+
+// FS holds the migrations, applied in file name order. Files starting with
+// the line "-- control:" belong to the control database only.
+//
+//go:embed *.sql
+var FS embed.FS
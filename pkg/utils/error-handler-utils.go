@@ -1,13 +1,174 @@
 package utils
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"log"
+	"net"
 	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindUnavailable
+	KindUnauthorized
+	KindForbidden
+)
+
+// Sentinels for errors.Is, e.g. errors.Is(err, utils.ErrNotFound).
+var (
+	ErrInternal     = &AppError{Kind: KindInternal}
+	ErrNotFound     = &AppError{Kind: KindNotFound}
+	ErrConflict     = &AppError{Kind: KindConflict}
+	ErrValidation   = &AppError{Kind: KindValidation}
+	ErrUnavailable  = &AppError{Kind: KindUnavailable}
+	ErrUnauthorized = &AppError{Kind: KindUnauthorized}
+	ErrForbidden    = &AppError{Kind: KindForbidden}
 )
 
+// AppError is a domain error. Msg is safe to show to API clients, Err is the
+// underlying cause and is only logged. Fields lists the invalid fields of a
+// validation error.
+type AppError struct {
+	Kind   ErrorKind
+	Msg    string
+	Err    error
+	Fields []FieldError
+}
+
+func (e *AppError) Error() string {
+	return e.Msg
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+func (e *AppError) Is(target error) bool {
+	t, ok := target.(*AppError)
+	return ok && t.Msg == "" && t.Err == nil && t.Kind == e.Kind
+}
+
+// ErrorHandler returns msg as an AppError whose kind is derived from the
+// cause, logging err when it is a server side failure.
 func ErrorHandler(err error, msg string) error {
-	errorLogger := log.New(os.Stderr, "ERROR", log.Ldate|log.Ltime|log.Lshortfile)
-	errorLogger.Println(msg, err)
-	return fmt.Errorf(msg)
+	return newAppError(classifyError(err), err, msg)
+}
+
+func NotFoundError(err error, msg string) error {
+	return newAppError(KindNotFound, err, msg)
+}
+
+func ConflictError(err error, msg string) error {
+	return newAppError(KindConflict, err, msg)
+}
+
+func ValidationError(err error, msg string) error {
+	return newAppError(KindValidation, err, msg)
+}
+
+// InvalidFieldsError reports request payload violations found by the
+// validators. It is a client error, so it is not logged.
+func InvalidFieldsError(fields []FieldError) error {
+	return &AppError{Kind: KindValidation, Msg: "Request validation failed.", Fields: fields}
+}
+
+func UnavailableError(err error, msg string) error {
+	return newAppError(KindUnavailable, err, msg)
+}
+
+func UnauthorizedError(err error, msg string) error {
+	return newAppError(KindUnauthorized, err, msg)
+}
+
+func ForbiddenError(err error, msg string) error {
+	return newAppError(KindForbidden, err, msg)
+}
+
+// ErrorKindOf returns the kind of the first AppError in err's chain.
+func ErrorKindOf(err error) ErrorKind {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr.Kind
+	}
+	return KindInternal
+}
+
+// newAppError logs internal and unavailable errors only, client errors are
+// cheap for anyone to cause and would flood the log.
+func newAppError(kind ErrorKind, err error, msg string) error {
+	if kind == KindInternal || kind == KindUnavailable {
+		errorLogger := log.New(os.Stderr, "ERROR", log.Ldate|log.Ltime|log.Lshortfile)
+		errorLogger.Output(3, msg+" "+errString(err))
+	}
+	return &AppError{Kind: kind, Msg: msg, Err: err}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
+
+// MySQL server error numbers, see
+// https://mariadb.com/kb/en/mariadb-error-code-reference/
+const (
+	mysqlTooManyConnections = 1040
+	mysqlAccessDenied       = 1045
+	mysqlUnknownDatabase    = 1049
+	mysqlBadNull            = 1048
+	mysqlDuplicateEntry     = 1062
+	mysqlLockWaitTimeout    = 1205
+	mysqlDeadlock           = 1213
+	mysqlOutOfRange         = 1264
+	mysqlTruncatedValue     = 1366
+	mysqlDataTooLong        = 1406
+	mysqlRowIsReferenced    = 1451
+	mysqlNoReferencedRow    = 1452
+)
+
+func classifyError(err error) ErrorKind {
+	if err == nil {
+		return KindInternal
+	}
+
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr.Kind
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return KindNotFound
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry, mysqlRowIsReferenced:
+			return KindConflict
+		case mysqlNoReferencedRow, mysqlBadNull, mysqlOutOfRange, mysqlTruncatedValue, mysqlDataTooLong:
+			return KindValidation
+		case mysqlTooManyConnections, mysqlAccessDenied, mysqlUnknownDatabase, mysqlLockWaitTimeout, mysqlDeadlock:
+			return KindUnavailable
+		}
+		return KindInternal
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return KindUnavailable
+	}
+
+	return KindInternal
 }
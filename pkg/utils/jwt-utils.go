@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	jwtIssuer = "golang-basic-crud-api"
+
+	// Access tokens and the short-lived tokens of an unfinished two-factor
+	// login are signed with the same key, the audience keeps them apart.
+	accessAudience = "api"
+	mfaAudience    = "mfa"
+)
+
+type AccessClaims struct {
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	TeacherID int    `json:"teacher_id,omitempty"`
+	StudentID int    `json:"student_id,omitempty"`
+	Class     string `json:"class,omitempty"`
+	// Tenant is the slug of the tenant that issued the token, empty for the
+	// default tenant. Tokens are only accepted by their own tenant.
+	Tenant string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// SignAccessToken issues a short-lived HS256 access token for a principal.
+// The lifetime is read from JWT_ACCESS_TTL and defaults to 15 minutes.
+func SignAccessToken(p Principal) (string, *AccessClaims, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &AccessClaims{
+		Username:  p.Username,
+		Role:      p.Role,
+		TeacherID: p.TeacherID,
+		StudentID: p.StudentID,
+		Class:     p.Class,
+		Tenant:    p.Tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{accessAudience},
+			Subject:   strconv.Itoa(p.AccountID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(DurationFromEnv("JWT_ACCESS_TTL", 15*time.Minute))),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+func ParseAccessToken(token string) (*AccessClaims, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &AccessClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		return secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(accessAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+type MFAClaims struct {
+	// Purpose is "verify" for a login waiting for a code and "enroll" for a
+	// login that must set up two-factor authentication first.
+	Purpose string `json:"purpose"`
+	Tenant  string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// SignMFAToken issues the 5 minute token that carries a password-verified
+// login into the second factor step. It grants no API access.
+func SignMFAToken(accountId int, purpose, tenant string) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &MFAClaims{
+		Purpose: purpose,
+		Tenant:  tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			Subject:   strconv.Itoa(accountId),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// ParseMFAToken returns the account of a valid MFA token issued for purpose
+// by tenant.
+func ParseMFAToken(token, purpose, tenant string) (int, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return 0, err
+	}
+
+	claims := &MFAClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		return secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(mfaAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if claims.Purpose != purpose {
+		return 0, errors.New("mfa token issued for another purpose")
+	}
+	if claims.Tenant != tenant {
+		return 0, errors.New("mfa token issued by another tenant")
+	}
+	return strconv.Atoi(claims.Subject)
+}
+
+func jwtSecret() ([]byte, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if len(secret) < 32 {
+		return nil, errors.New("JWT_SECRET must be set to at least 32 characters")
+	}
+	return []byte(secret), nil
+}
+
+// RandomToken returns n random bytes, hex encoded.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 of an opaque token. High entropy tokens do
+// not need a slow password hash, only protection against a leaked table.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func DurationFromEnv(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+func IntFromEnv(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
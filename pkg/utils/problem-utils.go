@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Problem is an RFC 7807 problem details document.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError points at a single invalid field of the request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, detail string, fieldErrors ...FieldError) {
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: RequestID(r),
+		Errors:    fieldErrors,
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// WriteError answers with the problem matching the kind of err. Only the
+// message of an AppError reaches the client; anything unclassified may carry
+// driver or SQL text, so it is logged and reported as a generic internal
+// error.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var appErr *AppError
+	if !errors.As(err, &appErr) {
+		errorLogger := log.New(os.Stderr, "ERROR", log.Ldate|log.Ltime|log.Lshortfile)
+		errorLogger.Output(2, r.Method+" "+r.URL.Path+" request "+RequestID(r)+": "+errString(err))
+		WriteProblem(w, r, http.StatusInternalServerError, "Internal server error.")
+		return
+	}
+	WriteProblem(w, r, HTTPStatus(appErr), appErr.Msg, appErr.Fields...)
+}
+
+func HTTPStatus(err error) int {
+	switch ErrorKindOf(err) {
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindValidation:
+		return http.StatusUnprocessableEntity
+	case KindUnavailable:
+		return http.StatusServiceUnavailable
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// Principal is the authenticated caller of a request. TeacherID, StudentID
+// and Class link teacher and student accounts to the records they own.
+// Machine clients authenticated by an API key carry APIKeyID and Scopes.
+// Browser clients authenticated by a session cookie carry SessionID and the
+// CSRFToken their unsafe requests must repeat.
+type Principal struct {
+	AccountID int
+	Username  string
+	Role      string
+	TeacherID int
+	StudentID int
+	Class     string
+	APIKeyID  int
+	Scopes    []string
+	TokenID   string
+	ExpiresAt time.Time
+	SessionID int
+	CSRFToken string
+	// Tenant is the slug of the tenant the credentials were issued by,
+	// empty for the default tenant.
+	Tenant string
+}
+
+const principalKey contextKey = "principal"
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFrom returns the authenticated caller, or nil for anonymous
+// requests.
+func PrincipalFrom(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey).(*Principal)
+	return p
+}
+
+// Tenant is the school a request is served for. Every tenant has its own
+// database.
+type Tenant struct {
+	Slug     string
+	Database string
+}
+
+const tenantKey contextKey = "tenant"
+
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey, t)
+}
+
+// TenantFrom returns the tenant of the request, or nil for the default
+// tenant served from the control database.
+func TenantFrom(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantKey).(*Tenant)
+	return t
+}
+
+// TenantSlug returns the slug of the tenant of ctx, empty for the default
+// tenant.
+func TenantSlug(ctx context.Context) string {
+	if t := TenantFrom(ctx); t != nil {
+		return t.Slug
+	}
+	return ""
+}
+
+const paramsKey contextKey = "params"
+
+func WithParams(ctx context.Context, params url.Values) context.Context {
+	return context.WithValue(ctx, paramsKey, params)
+}
+
+// Params returns the query and form parameters of the request with repeated
+// parameters resolved by the HPP middleware, form values taking precedence.
+// Without the middleware it falls back to the raw query.
+func Params(r *http.Request) url.Values {
+	if params, ok := r.Context().Value(paramsKey).(url.Values); ok {
+		return params
+	}
+	return r.URL.Query()
+}
+
+const clientIPKey contextKey = "clientIP"
+
+// WithClientIP records the address of the client behind any trusted
+// proxies.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIP returns the address of the client that sent the request: the one
+// resolved by the client IP middleware, else the immediate peer.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return PeerIP(r)
+}
+
+// PeerIP returns the address of the immediate peer, which may be a proxy.
+func PeerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// EncryptSecret seals secrets that must be stored recoverably, such as TOTP
+// seeds, with AES-256-GCM under a key derived from TOTP_ENCRYPTION_KEY. The
+// key is kept apart from JWT_SECRET, so rotating the signing secret after a
+// leak does not make the stored secrets unreadable.
+func EncryptSecret(plaintext string) (string, error) {
+	key, err := secretKey("TOTP_ENCRYPTION_KEY")
+	if err != nil {
+		return "", err
+	}
+	gcm, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret opens a secret sealed under TOTP_ENCRYPTION_KEY or, while
+// keys are rotated, TOTP_ENCRYPTION_KEY_PREVIOUS. stale reports the latter,
+// such secrets are to be sealed again with EncryptSecret. Secrets sealed
+// before the key was introduced open with the old JWT_SECRET as previous key.
+func DecryptSecret(encoded string) (plaintext string, stale bool, err error) {
+	key, err := secretKey("TOTP_ENCRYPTION_KEY")
+	if err != nil {
+		return "", false, err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false, err
+	}
+
+	plaintext, err = openSecret(key, sealed)
+	if err == nil {
+		return plaintext, false, nil
+	}
+	if os.Getenv("TOTP_ENCRYPTION_KEY_PREVIOUS") == "" {
+		return "", false, err
+	}
+
+	previous, err := secretKey("TOTP_ENCRYPTION_KEY_PREVIOUS")
+	if err != nil {
+		return "", false, err
+	}
+	plaintext, err = openSecret(previous, sealed)
+	if err != nil {
+		return "", false, err
+	}
+	return plaintext, true, nil
+}
+
+func openSecret(key []byte, sealed []byte) (string, error) {
+	gcm, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("sealed secret too short")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func secretKey(env string) ([]byte, error) {
+	key := os.Getenv(env)
+	if len(key) < 32 {
+		return nil, fmt.Errorf("%s must be set to at least 32 characters", env)
+	}
+	return []byte(key), nil
+}
+
+func secretCipher(secret []byte) (cipher.AEAD, error) {
+	// domain separated so the encryption key never equals a signing key
+	key := sha256.Sum256(append([]byte("secretbox:"), secret...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
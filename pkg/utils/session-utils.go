@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"net/http"
+	"time"
+)
+
+// SessionCookie holds the ID of a browser session. The __Host- prefix makes
+// browsers insist on Secure, Path=/ and no Domain, so subdomains cannot
+// plant or read it.
+const SessionCookie = "__Host-session"
+
+// CSRFHeader carries the CSRF token of the session on unsafe requests.
+const CSRFHeader = "X-CSRF-Token"
+
+// SessionIdleTimeout ends sessions unused for SESSION_IDLE_TIMEOUT (30m).
+func SessionIdleTimeout() time.Duration {
+	return DurationFromEnv("SESSION_IDLE_TIMEOUT", 30*time.Minute)
+}
+
+// SessionAbsoluteTimeout ends every session SESSION_ABSOLUTE_TIMEOUT (12h)
+// after login, however active it is.
+func SessionAbsoluteTimeout() time.Duration {
+	return DurationFromEnv("SESSION_ABSOLUTE_TIMEOUT", 12*time.Hour)
+}
+
+func SetSessionCookie(w http.ResponseWriter, value string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validation rules are declared with a `validate` struct tag, e.g.
+//
+//	Email string `json:"email" validate:"required,max=255,email"`
+//
+// Supported rules: required, min=N, max=N (characters for strings), email,
+// pattern=<name> (see patterns) and oneof=a|b|c.
+
+type pattern struct {
+	re          *regexp.Regexp
+	description string
+}
+
+var patterns = map[string]pattern{
+	"class": {regexp.MustCompile(`^(1[0-2]|[1-9])[A-Z]$`), "a grade from 1 to 12 followed by a capital letter, e.g. 9A"},
+	"name":  {regexp.MustCompile(`^[\p{L}][\p{L} '\-]*$`), "letters, spaces, apostrophes and hyphens only"},
+	"slug":  {regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`), "lowercase letters, digits and hyphens, not starting with a hyphen"},
+}
+
+// ValidateStruct checks every tagged field of v, which must be a struct or a
+// pointer to one, and returns all violations.
+func ValidateStruct(v any) []FieldError {
+	return validateStruct(reflect.Indirect(reflect.ValueOf(v)), "$", nil)
+}
+
+// ValidateSlice validates each element of a bulk payload, prefixing the
+// field paths with the element index.
+func ValidateSlice[T any](items []T) []FieldError {
+	var errs []FieldError
+	for i := range items {
+		errs = validateStruct(reflect.Indirect(reflect.ValueOf(items[i])), fmt.Sprintf("$[%d]", i), errs)
+	}
+	return errs
+}
+
+// ValidatePartial validates a PATCH document against the rules of model.
+// Only keys present in updates are checked; unknown keys are violations.
+// Keys listed in ignore (e.g. "id") are skipped.
+func ValidatePartial(model any, updates map[string]any, path string, ignore ...string) []FieldError {
+	var errs []FieldError
+	if path == "" {
+		path = "$"
+	}
+
+	fields := jsonFields(reflect.Indirect(reflect.ValueOf(model)).Type())
+	for _, key := range slices.Sorted(maps.Keys(updates)) {
+		value := updates[key]
+		if slices.Contains(ignore, key) {
+			continue
+		}
+
+		fieldPath := path + "." + key
+		field, ok := fields[key]
+		if !ok {
+			errs = append(errs, FieldError{Field: fieldPath, Message: "is not a known field"})
+			continue
+		}
+
+		rv, ok := jsonValue(value, field.Type)
+		if !ok {
+			errs = append(errs, FieldError{Field: fieldPath, Message: "must be " + typeName(field.Type)})
+			continue
+		}
+
+		errs = validateField(rv, field.Tag.Get("validate"), fieldPath, errs)
+	}
+	return errs
+}
+
+func validateStruct(v reflect.Value, path string, errs []FieldError) []FieldError {
+	if v.Kind() != reflect.Struct {
+		return append(errs, FieldError{Field: path, Message: "must be an object"})
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		rules := field.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+		errs = validateField(v.Field(i), rules, path+"."+jsonName(field), errs)
+	}
+	return errs
+}
+
+func validateField(v reflect.Value, rules string, path string, errs []FieldError) []FieldError {
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+
+		if msg := checkRule(v, name, arg); msg != "" {
+			errs = append(errs, FieldError{Field: path, Message: msg})
+			// one message per field is enough, later rules usually repeat it
+			break
+		}
+	}
+	return errs
+}
+
+func checkRule(v reflect.Value, name, arg string) string {
+	if name != "required" && v.IsZero() {
+		// optional fields are only checked when present
+		return ""
+	}
+
+	switch name {
+	case "required":
+		if v.IsZero() || v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "" {
+			return "is required"
+		}
+	case "min", "max":
+		limit, _ := strconv.Atoi(arg)
+		size := fieldSize(v)
+		if name == "min" && size < limit {
+			return fmt.Sprintf("must be at least %d%s", limit, sizeUnit(v))
+		}
+		if name == "max" && size > limit {
+			return fmt.Sprintf("must be at most %d%s", limit, sizeUnit(v))
+		}
+	case "email":
+		addr, err := mail.ParseAddress(v.String())
+		if err != nil || addr.Address != v.String() {
+			return "must be a valid email address"
+		}
+	case "pattern":
+		p, ok := patterns[arg]
+		if ok && !p.re.MatchString(v.String()) {
+			return "must be " + p.description
+		}
+	case "oneof":
+		options := strings.Split(arg, "|")
+		if !slices.Contains(options, v.String()) {
+			return "must be one of: " + strings.Join(options, ", ")
+		}
+	}
+	return ""
+}
+
+func fieldSize(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int())
+	}
+	return 0
+}
+
+func sizeUnit(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return " characters"
+	}
+	return ""
+}
+
+// jsonValue converts a value decoded by encoding/json into the field type.
+func jsonValue(value any, t reflect.Type) (reflect.Value, bool) {
+	switch t.Kind() {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			return reflect.ValueOf(s).Convert(t), true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f, ok := value.(float64); ok && f == float64(int64(f)) {
+			return reflect.ValueOf(int64(f)).Convert(t), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// JSONID reads an ID sent in a JSON document as a number, decoded as float64
+// or json.Number, or as a numeric string.
+func JSONID(value any) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), true
+		}
+	case json.Number:
+		if id, err := strconv.Atoi(v.String()); err == nil {
+			return id, true
+		}
+	case string:
+		if id, err := strconv.Atoi(v); err == nil {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func typeName(t reflect.Type) string {
+	if t.Kind() == reflect.String {
+		return "a string"
+	}
+	return "an integer"
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		fields[jsonName(field)] = field
+	}
+	return fields
+}